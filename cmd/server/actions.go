@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// allGroupsTarget is the group name GPT uses for requests that affect every group.
+const allGroupsTarget = "all"
+
+// resetKeyword is the text that clears the sender's conversation memory.
+const resetKeyword = "reset"
+
+// processText converts natural-language text from the user into actions with the
+// intent parser and runs them. It returns the reply for the user, even on error.
+func (app *application) processText(user service.User, text string) (string, error) {
+	// "reset" starts a new conversation
+	if strings.EqualFold(strings.TrimSpace(text), resetKeyword) {
+		app.conversations.Reset(user.Phone)
+		return "Okay, I've forgotten our conversation.", nil
+	}
+
+	// A YES or NO answers a request waiting for confirmation
+	if reply, ok := app.answerConfirmation(user, text); ok {
+		return reply, nil
+	}
+
+	// User management commands don't go through the intent parser
+	if reply, ok := app.adminCommand(user, text); ok {
+		return reply, nil
+	}
+
+	// A reply to a clarifying question completes the original request
+	text = app.answerQuestion(user, text)
+
+	// The prompt lists the groups of every known client the user may control
+	groups := app.userGroups(user)
+	if len(groups) == 0 {
+		if len(app.clients.displayGroups()) > 0 {
+			return "You aren't allowed to control any of the groups.", fmt.Errorf("user %s has no groups", user.Name)
+		}
+		return "No home client is connected. \n Please try again later.", errNoClients
+	}
+
+	now := time.Now()
+	req := service.IntentRequest{
+		Text:    text,
+		Groups:  groups,
+		Scenes:  app.userScenes(user),
+		Now:     now,
+		History: app.conversations.History(user.Phone, now),
+	}
+
+	// Invalid actions are sent back to the parser once with the problem explained
+	var actions []service.Intent
+	for attempt := 1; ; attempt++ {
+		var response string
+		var invalid *service.InvalidIntentError
+		intents, err := app.intents.ParseIntent(req)
+		switch {
+		case errors.Is(err, service.ErrNoIntent):
+			return "Sorry, I didn't understand that. Try something like \"kitchen off\".", err
+		case errors.As(err, &invalid):
+			response = invalid.Response
+			err = fmt.Errorf("The response is not valid: %w.", invalid.Err)
+		case err != nil:
+			return "There was an error communicating with openai. \n Please try again.", err
+		default:
+			response = service.FormatIntents(intents)
+			err = app.validateActions(intents, req.Groups, req.Scenes)
+		}
+
+		app.recordHistory(service.HistoryIntent, user.Phone, "", response, nil)
+
+		if err == nil {
+			actions = intents
+			app.conversations.Add(user.Phone, service.Turn{Text: text, Actions: response, Time: now})
+			break
+		}
+
+		app.logger.Warn("rejected intent", "attempt", attempt, "intent", response, "problem", err)
+		if attempt == 2 {
+			return err.Error(), err
+		}
+		req.Previous, req.Problem = response, err.Error()
+	}
+
+	// Nothing runs until an ambiguous request has been clarified
+	if clarifyRequest, ok := clarifyAction(actions); ok {
+		return app.askQuestion(user, text, clarifyRequest), nil
+	}
+
+	// Bulk and sensitive changes wait for the user to confirm them
+	if prompt, ok := app.confirmationPrompt(actions, groups, now); ok {
+		app.holdForConfirmation(user, text, actions, now)
+		return prompt, nil
+	}
+
+	// Run every action in order and reply once with all of the outcomes
+	return app.executeActions(user, actions), nil
+}
+
+// executeActions runs each action in order for the user and returns a single reply
+// summarizing the outcome of every one of them.
+func (app *application) executeActions(user service.User, actions []service.Intent) string {
+	replies := make([]string, 0, len(actions))
+	for _, action := range actions {
+		reply, err := app.executeAction(user, action)
+		if err != nil {
+			app.logger.Error("error executing action", "type", action.Type, "error", err)
+			app.events.publish(eventError, envelope{"action": action, "error": err.Error()})
+		}
+		replies = append(replies, strings.TrimSpace(reply))
+	}
+	return strings.Join(replies, "\n")
+}
+
+// executeAction runs a single action for the user based on its type. It returns the
+// text to send back to the user, even on error.
+func (app *application) executeAction(user service.User, action service.Intent) (string, error) {
+	err := app.authorizeAction(user, action)
+	if err != nil {
+		return err.Error(), err
+	}
+
+	switch action.Type {
+	case "status":
+		data, err := actionData[GPTStatusData](action)
+		if err != nil {
+			return "There was an error processing your request.", err
+		}
+		return app.executeStatus(GPTStatusRequest{Data: data})
+	case "update":
+		updateRequest, err := actionData[GPTUpdateRequest](action)
+		if err != nil {
+			return "There was an error processing your request.", err
+		}
+		// Log the received update request for debugging
+		app.logger.Info("received update request", "request", fmt.Sprintf("%+v", updateRequest))
+		return app.executeUpdate(updateRequest)
+	case "scene":
+		sceneRequest, err := actionData[GPTSceneRequest](action)
+		if err != nil {
+			return "There was an error processing your request.", err
+		}
+		return app.executeScene(sceneRequest)
+	case "schedule":
+		scheduleRequest, err := actionData[GPTScheduleRequest](action)
+		if err != nil {
+			return "There was an error processing your request.", err
+		}
+		return app.executeSchedule(user, scheduleRequest)
+	case "list_schedules":
+		return app.scheduler.ListMessage(func(sc service.Schedule) bool { return app.manages(user, sc.Owner) }), nil
+	case "cancel_schedule":
+		cancelRequest, err := actionData[GPTCancelScheduleRequest](action)
+		if err != nil {
+			return "There was an error processing your request.", err
+		}
+		return app.executeCancelSchedule(user, cancelRequest)
+	case "create_rule":
+		ruleRequest, err := actionData[GPTRuleRequest](action)
+		if err != nil {
+			return "There was an error processing your request.", err
+		}
+		return app.executeCreateRule(user, ruleRequest)
+	case "list_rules":
+		return app.rules.ListMessage(func(r service.Rule) bool { return app.manages(user, r.Owner) }), nil
+	case "pause_rule", "resume_rule", "delete_rule":
+		ruleRequest, err := actionData[GPTRuleIDRequest](action)
+		if err != nil {
+			return "There was an error processing your request.", err
+		}
+		return app.executeRuleChange(user, action.Type, ruleRequest)
+	default:
+		return "Sorry, I didn't understand that request.", fmt.Errorf("unknown action type %q", action.Type)
+	}
+}
+
+// executeStatus refreshes the state of every client and describes the requested groups.
+func (app *application) executeStatus(statusRequest GPTStatusRequest) (string, error) {
+	// Update group state field
+	err := app.SetGroupsStateField()
+	groups := app.clients.displayGroups()
+
+	// Without a connected client, fall back to the last state it reported
+	if errors.Is(err, errNoClients) && len(groups) > 0 {
+		return "No home client is connected. Last known state:\n" + groups.GroupStatusMessage(statusRequest.Data.Rooms), err
+	}
+
+	// Groups of clients that didn't answer show the last state they reported
+	var failed groupsStateError
+	if errors.As(err, &failed) {
+		msg := groups.GroupStatusMessage(statusRequest.Data.Rooms)
+		msg += fmt.Sprintf("(No answer from %s, showing its last known state.)", strings.Join(failed.clientIDs(), ", "))
+		return msg, err
+	}
+	if err != nil {
+		return "There was an error getting the groups state. \n Please try again.", err
+	}
+
+	return groups.GroupStatusMessage(statusRequest.Data.Rooms), nil
+}
+
+// executeUpdate sends an update to the client that owns the group and waits for it to
+// report the result. It returns the text to send back to the user, even on error.
+func (app *application) executeUpdate(updateRequest GPTUpdateRequest) (string, error) {
+	if strings.EqualFold(updateRequest.Group, allGroupsTarget) {
+		return app.executeUpdateAll(updateRequest)
+	}
+
+	// Find the client that owns the group
+	target, ok := app.clients.lookupGroup(updateRequest.Group)
+	if !ok {
+		return fmt.Sprintf("I don't know a group called '%s'.", updateRequest.Group),
+			fmt.Errorf("no client owns group %q", updateRequest.Group)
+	}
+
+	// Relative changes are computed from the group's live brightness
+	if updateRequest.BrightnessDelta != nil {
+		err := app.requestGroupsState(target.client)
+		if err != nil {
+			return fmt.Sprintf("%s: there was an error getting the current brightness.", target.Name), err
+		}
+
+		current, ok := app.clients.lookupGroup(updateRequest.Group)
+		if !ok {
+			return fmt.Sprintf("I don't know a group called '%s'.", updateRequest.Group),
+				fmt.Errorf("group %q disappeared after refreshing state", updateRequest.Group)
+		}
+		target = current
+
+		brightness := target.BrightnessAfter(*updateRequest.BrightnessDelta)
+		updateRequest.Brightness = &brightness
+		updateRequest.IsOn = brightness > 0
+		updateRequest.BrightnessDelta = nil
+	}
+
+	clientUpdateData, err := newClientUpdateData(updateRequest, target.bridgeName)
+	if err != nil {
+		return err.Error(), err
+	}
+
+	// Prepare Client Update Message
+	clientUpdateMessage := JSONMessage{
+		Type: "update",
+		Data: clientUpdateData,
+	}
+
+	// Send Update Message to Client via WebSocket and wait for the result
+	return app.applyToClient(target, clientUpdateMessage)
+}
+
+// executeScene asks the client that owns the group to recall the scene and waits for
+// it to report the result. It returns the text to send back to the user, even on error.
+func (app *application) executeScene(sceneRequest GPTSceneRequest) (string, error) {
+	target, ok := app.clients.lookupGroup(sceneRequest.Group)
+	if !ok {
+		return fmt.Sprintf("I don't know a group called '%s'.", sceneRequest.Group),
+			fmt.Errorf("no client owns group %q", sceneRequest.Group)
+	}
+
+	scenes := target.scenes()
+	scene, ok := scenes.Lookup(target.bridgeName, sceneRequest.Scene)
+	if !ok {
+		msg := fmt.Sprintf("I don't know a scene called '%s' for %s.", sceneRequest.Scene, target.Name)
+		if len(scenes) > 0 {
+			msg += fmt.Sprintf(" Known scenes: %s", strings.Join(scenes.Names(), ", "))
+		}
+		return msg, fmt.Errorf("group %q has no scene %q", target.Name, sceneRequest.Scene)
+	}
+
+	recallSceneMessage := JSONMessage{
+		Type: "recall_scene",
+		Data: ClientSceneData{
+			Group:   target.bridgeName,
+			SceneID: scene.ID,
+			Scene:   scene.Name,
+		},
+	}
+
+	reply, err := app.applyToClient(target, recallSceneMessage)
+	if err != nil {
+		return reply, err
+	}
+	return fmt.Sprintf("%s (%s)", reply, scene.Name), nil
+}
+
+// applyToClient sends msg to the client that owns the target group, waits for its
+// update_result reply and records the resulting group state. It returns the text to
+// send back to the user, even on error.
+func (app *application) applyToClient(target clientGroup, msg JSONMessage) (string, error) {
+	result, err := app.awaitUpdateResult(target.client, msg)
+	if err != nil {
+		return fmt.Sprintf("%s: %s", target.Name, err), err
+	}
+
+	if !result.Success {
+		return fmt.Sprintf("%s: update failed. %s", target.Name, result.FailureReason()), fmt.Errorf("client reported failure: %s", result.FailureReason())
+	}
+
+	if result.Group.State == nil {
+		return fmt.Sprintf("%s: updated", target.Name), nil
+	}
+	result.Group.Name = target.Name
+	return result.Group.StatusLine(), nil
+}
+
+// executeUpdateAll sends the update to every client, which applies it to all of its
+// lights through the bridge's group 0, and lists the resulting state of each group.
+func (app *application) executeUpdateAll(updateRequest GPTUpdateRequest) (string, error) {
+	clients := app.clients.connected()
+	if len(clients) == 0 {
+		return "No home client is connected.", errNoClients
+	}
+
+	// Every group starts from its own brightness, so relative changes go group by group
+	if updateRequest.BrightnessDelta != nil {
+		return app.executeUpdateEach(updateRequest)
+	}
+
+	var lines []string
+	var errs []error
+	for _, client := range clients {
+		clientUpdateData, err := newClientUpdateData(updateRequest, allGroupsTarget)
+		if err != nil {
+			return err.Error(), err
+		}
+		clientUpdateData.All = true
+
+		clientUpdateMessage := JSONMessage{
+			Type: "update",
+			Data: clientUpdateData,
+		}
+
+		result, err := app.awaitUpdateResult(client, clientUpdateMessage)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%s: %s", client.id, err))
+			errs = append(errs, fmt.Errorf("client %s: %w", client.id, err))
+			continue
+		}
+		if !result.Success {
+			lines = append(lines, fmt.Sprintf("%s: update failed. %s", client.id, result.FailureReason()))
+			errs = append(errs, fmt.Errorf("client %s reported failure: %s", client.id, result.FailureReason()))
+		}
+	}
+
+	// Describe every group from the state the clients reported back
+	groups := app.clients.displayGroups()
+	for _, g := range groups {
+		lines = append(lines, g.StatusLine())
+	}
+	return strings.Join(lines, "\n"), errors.Join(errs...)
+}
+
+// executeUpdateEach applies the update to every group one at a time.
+func (app *application) executeUpdateEach(updateRequest GPTUpdateRequest) (string, error) {
+	var lines []string
+	var errs []error
+	for _, g := range app.clients.displayGroups() {
+		groupRequest := updateRequest
+		groupRequest.Group = g.Name
+
+		line, err := app.executeUpdate(groupRequest)
+		lines = append(lines, line)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return strings.Join(lines, "\n"), errors.Join(errs...)
+}
+
+// awaitUpdateResult sends msg to the client, waits for its update_result reply and
+// records the group states it reports.
+func (app *application) awaitUpdateResult(client *homeClient, msg JSONMessage) (UpdateResult, error) {
+	app.recordHistory(service.HistoryCommand, "", client.id, "", msg)
+
+	reply, err := client.request(msg, wsRequestTimeout)
+	if err != nil {
+		app.recordHistory(service.HistoryResult, "", client.id, err.Error(), nil)
+		app.events.publish(eventCommand, envelope{"clientId": client.id, "command": msg, "error": err.Error()})
+		return UpdateResult{}, fmt.Errorf("the home client did not confirm the update: %w", err)
+	}
+	app.recordHistory(service.HistoryResult, "", client.id, "", reply)
+	app.events.publish(eventCommand, envelope{"clientId": client.id, "command": msg, "result": reply.Data})
+
+	if reply.Type != "update_result" {
+		return UpdateResult{}, fmt.Errorf("the home client sent an unexpected %q reply", reply.Type)
+	}
+
+	var result UpdateResult
+	err = decodeMessageData(reply, &result)
+	if err != nil {
+		return UpdateResult{}, fmt.Errorf("the home client sent an unreadable reply: %w", err)
+	}
+
+	// Record the resulting state the client reported
+	old := client.groups()
+	if result.Group.Name != "" {
+		client.updateGroup(result.Group)
+	}
+	for _, g := range result.Groups {
+		client.updateGroup(g)
+	}
+	if result.Group.Name != "" || len(result.Groups) > 0 {
+		app.groupsChanged(client, old, false)
+	}
+	return result, nil
+}
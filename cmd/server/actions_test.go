@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// scriptedParser records every request and answers with the actions given for each
+// one in turn, repeating the last once they run out.
+type scriptedParser struct {
+	responses []string
+	requests  []service.IntentRequest
+}
+
+func (p *scriptedParser) ParseIntent(req service.IntentRequest) ([]service.Intent, error) {
+	p.requests = append(p.requests, req)
+	response := p.responses[min(len(p.requests), len(p.responses))-1]
+	return parseActions(response)
+}
+
+// last returns the most recent request the parser received.
+func (p *scriptedParser) last(t *testing.T) service.IntentRequest {
+	t.Helper()
+	if len(p.requests) == 0 {
+		t.Fatal("the parser got no request")
+	}
+	return p.requests[len(p.requests)-1]
+}
+
+// newConversationTestApp returns an application with a Kitchen and a Porch whose
+// intents come from a parser answering with a Kitchen status request.
+func newConversationTestApp(t *testing.T) (*application, *scriptedParser, service.User) {
+	t.Helper()
+
+	app, _ := newTestApp(t)
+	app.clients.add(newTestClient("home", "Kitchen", "Porch"))
+	parser := &scriptedParser{responses: []string{`{"type":"status","data":{"room":["Kitchen"]}}`}}
+	app.intents = parser
+
+	user, _ := app.users.Lookup(testOwner)
+	return app, parser, user
+}
+
+// historyTexts returns the text of each remembered turn.
+func historyTexts(turns []service.Turn) []string {
+	var texts []string
+	for _, turn := range turns {
+		texts = append(texts, turn.Text)
+	}
+	return texts
+}
+
+func TestProcessTextConversationWindow(t *testing.T) {
+	app, parser, user := newConversationTestApp(t)
+	app.conversations = service.NewConversations(2, 10*time.Minute)
+
+	for _, text := range []string{"is the kitchen on", "and now", "how about now", "still"} {
+		if _, err := app.processText(user, text); err != nil {
+			t.Logf("%q: %v", text, err)
+		}
+	}
+
+	// Each request carries the turns before it, up to the window
+	want := [][]string{nil, {"is the kitchen on"}, {"is the kitchen on", "and now"}, {"and now", "how about now"}}
+	for i, req := range parser.requests {
+		if got := historyTexts(req.History); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("request %d: got history %q, want %q", i+1, got, want[i])
+		}
+	}
+	for _, turn := range parser.last(t).History {
+		if turn.Actions == "" {
+			t.Errorf("got turn %+v without the actions it was understood as", turn)
+		}
+	}
+}
+
+func TestProcessTextConversationExpiry(t *testing.T) {
+	app, parser, user := newConversationTestApp(t)
+	app.conversations = service.NewConversations(5, 10*time.Minute)
+
+	app.conversations.Add(user.Phone, service.Turn{Text: "kitchen on", Time: time.Now().Add(-time.Hour)})
+	app.processText(user, "a bit brighter")
+	if history := parser.last(t).History; len(history) != 0 {
+		t.Errorf("got history %+v, want an expired conversation forgotten", history)
+	}
+
+	app.conversations.Reset(user.Phone)
+	app.conversations.Add(user.Phone, service.Turn{Text: "kitchen on", Time: time.Now().Add(-time.Minute)})
+	app.processText(user, "a bit brighter")
+	if got := historyTexts(parser.last(t).History); !reflect.DeepEqual(got, []string{"kitchen on"}) {
+		t.Errorf("got history %q, want the recent turn", got)
+	}
+}
+
+func TestProcessTextReset(t *testing.T) {
+	app, parser, user := newConversationTestApp(t)
+
+	app.processText(user, "is the kitchen on")
+	app.processText(user, "and the porch")
+
+	for _, text := range []string{"reset", " RESET "} {
+		reply, err := app.processText(user, text)
+		if err != nil || reply != "Okay, I've forgotten our conversation." {
+			t.Errorf("%q: got %q, %v; want the conversation forgotten", text, reply, err)
+		}
+	}
+	if len(parser.requests) != 2 {
+		t.Errorf("got %d requests, want reset handled without the parser", len(parser.requests))
+	}
+
+	app.processText(user, "is the kitchen on")
+	if history := parser.last(t).History; len(history) != 0 {
+		t.Errorf("got history %+v after a reset, want none", history)
+	}
+
+	// Resetting only forgets the sender's own conversation
+	other := service.User{Phone: "fake:alice", Name: "Alice"}
+	app.processText(other, "is the kitchen on")
+	app.processText(user, "reset")
+	app.processText(other, "and now")
+	if got := historyTexts(parser.last(t).History); !reflect.DeepEqual(got, []string{"is the kitchen on"}) {
+		t.Errorf("got history %q for another user, want theirs kept", got)
+	}
+}
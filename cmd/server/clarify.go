@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// GPTClarifyRequest represents a question GPT asks when a request is ambiguous.
+type GPTClarifyRequest struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options,omitempty"`
+}
+
+// pendingQuestion is a clarifying question waiting for the sender's answer.
+type pendingQuestion struct {
+	Text     string // the original request
+	Question string
+	Options  []string
+}
+
+// clarifyAction returns the clarifying question among actions, if GPT asked one.
+func clarifyAction(actions []service.Intent) (GPTClarifyRequest, bool) {
+	for _, action := range actions {
+		if clarifyRequest, ok := action.Data.(GPTClarifyRequest); ok && clarifyRequest.Question != "" {
+			return clarifyRequest, true
+		}
+	}
+	return GPTClarifyRequest{}, false
+}
+
+// askQuestion stores the question for the user's next text and returns it with its
+// options numbered so they can be answered with "1" or "2".
+func (app *application) askQuestion(user service.User, text string, clarifyRequest GPTClarifyRequest) string {
+	app.questions.Put(user.Phone, pendingQuestion{
+		Text:     text,
+		Question: clarifyRequest.Question,
+		Options:  clarifyRequest.Options,
+	}, time.Now())
+
+	lines := []string{clarifyRequest.Question}
+	for i, option := range clarifyRequest.Options {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, option))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// answerQuestion combines the user's text with the question it answers into the
+// complete request. It returns the text unchanged when no question is pending or the
+// text doesn't answer one that offered options.
+func (app *application) answerQuestion(user service.User, text string) string {
+	q, ok, _ := app.questions.Take(user.Phone, time.Now())
+	if !ok {
+		return text
+	}
+
+	answer := strings.TrimSpace(text)
+	if len(q.Options) > 0 {
+		n, err := strconv.Atoi(answer)
+		switch {
+		case err == nil && n >= 1 && n <= len(q.Options):
+			answer = q.Options[n-1]
+		case !containsFold(q.Options, answer):
+			return text
+		}
+	}
+	return fmt.Sprintf("%s (%s %s)", q.Text, q.Question, answer)
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
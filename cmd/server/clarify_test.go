@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+func TestAnswerQuestion(t *testing.T) {
+	question := pendingQuestion{Text: "turn on the light", Question: "Which light?", Options: []string{"Kitchen", "Porch"}}
+
+	tests := []struct {
+		name     string
+		question *pendingQuestion
+		asked    time.Time
+		text     string
+		want     string
+	}{
+		{
+			name:     "numbered answer",
+			question: &question,
+			text:     " 2 ",
+			want:     "turn on the light (Which light? Porch)",
+		},
+		{
+			name:     "option by name",
+			question: &question,
+			text:     "kitchen",
+			want:     "turn on the light (Which light? kitchen)",
+		},
+		{
+			name:     "number out of range",
+			question: &question,
+			text:     "3",
+			want:     "3",
+		},
+		{
+			name:     "zero",
+			question: &question,
+			text:     "0",
+			want:     "0",
+		},
+		{
+			name:     "new request instead of an answer",
+			question: &question,
+			text:     "porch off",
+			want:     "porch off",
+		},
+		{
+			name:     "open question",
+			question: &pendingQuestion{Text: "dim it", Question: "How dim?"},
+			text:     "very",
+			want:     "dim it (How dim? very)",
+		},
+		{
+			name:     "expired question",
+			question: &question,
+			asked:    time.Now().Add(-10 * time.Minute),
+			text:     "1",
+			want:     "1",
+		},
+		{
+			name: "no question",
+			text: "1",
+			want: "1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			user := service.User{Phone: testOwner}
+			if tt.question != nil {
+				asked := tt.asked
+				if asked.IsZero() {
+					asked = time.Now()
+				}
+				app.questions.Put(user.Phone, *tt.question, asked)
+			}
+
+			if got := app.answerQuestion(user, tt.text); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+
+			// A question is answered at most once, whatever the reply
+			if got := app.answerQuestion(user, "1"); got != "1" {
+				t.Errorf("got %q for a second answer, want the question gone", got)
+			}
+		})
+	}
+}
+
+func TestProcessTextClarify(t *testing.T) {
+	app, parser, user := newConversationTestApp(t)
+	parser.responses = []string{
+		`{"type":"clarify","data":{"question":"Which light?","options":["Kitchen","Porch"]}}`,
+		`{"type":"status","data":{"room":["Porch"]}}`,
+	}
+
+	reply, err := app.processText(user, "is the light on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Which light?\n1. Kitchen\n2. Porch"; reply != want {
+		t.Errorf("got question %q, want %q", reply, want)
+	}
+
+	// The numbered answer completes the original request
+	app.processText(user, "2")
+	if got, want := parser.last(t).Text, "is the light on (Which light? Porch)"; got != want {
+		t.Errorf("got request %q, want %q", got, want)
+	}
+
+	// With the question answered, a number is just a message again
+	app.processText(user, "2")
+	if got := parser.last(t).Text; got != "2" {
+		t.Errorf("got request %q, want the text unchanged", got)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	"github.com/gorilla/websocket"
+)
+
+// defaultClientID is used for home clients that do not send a client ID when connecting.
+const defaultClientID = "default"
+
+// homeClient is a home client and the last known state of the bridge behind it. A
+// client whose connection has closed is kept, without a connection, so its state
+// remains available.
+type homeClient struct {
+	id string
+
+	// writeMu serializes writes, since a websocket connection supports one concurrent
+	// writer. It also guards conn, which is nil while the client is offline.
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+
+	mu          sync.Mutex
+	groupsState service.Groups
+	scenes      service.Scenes
+	// pending holds the reply channel of every request awaiting a reply, keyed by message ID.
+	pending map[string]chan JSONMessage
+}
+
+func newHomeClient(id string, conn *websocket.Conn) *homeClient {
+	return &homeClient{
+		id:      id,
+		conn:    conn,
+		pending: make(map[string]chan JSONMessage),
+	}
+}
+
+// writeJSON sends v to the client as JSON.
+func (c *homeClient) writeJSON(v any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.conn == nil {
+		return errClientOffline
+	}
+	return c.conn.WriteJSON(v)
+}
+
+// connected reports whether the client currently has a connection.
+func (c *homeClient) connected() bool {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn != nil
+}
+
+// disconnect closes the client's connection, if any, and marks it offline.
+func (c *homeClient) disconnect() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// state returns the client's state for persisting.
+func (c *homeClient) state() service.ClientState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return service.ClientState{
+		ClientID:  c.id,
+		Groups:    append(service.Groups(nil), c.groupsState...),
+		Scenes:    append(service.Scenes(nil), c.scenes...),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// send assigns msg a new ID and sends it without waiting for a reply.
+func (c *homeClient) send(msg JSONMessage) error {
+	msg.ID = newMessageID()
+	return c.writeJSON(msg)
+}
+
+// request sends msg under a new ID and waits up to timeout for the client to
+// reply with the same ID.
+func (c *homeClient) request(msg JSONMessage, timeout time.Duration) (JSONMessage, error) {
+	msg.ID = newMessageID()
+
+	// Buffered so a reply arriving after the timeout never blocks the read loop.
+	replyChan := make(chan JSONMessage, 1)
+
+	c.mu.Lock()
+	c.pending[msg.ID] = replyChan
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, msg.ID)
+		c.mu.Unlock()
+	}()
+
+	err := c.writeJSON(msg)
+	if err != nil {
+		return JSONMessage{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-replyChan:
+		return reply, nil
+	case <-timer.C:
+		return JSONMessage{}, fmt.Errorf("timeout waiting for reply to %s request", msg.Type)
+	}
+}
+
+// resolve delivers msg to the request waiting on its ID and reports whether there was one.
+func (c *homeClient) resolve(msg JSONMessage) bool {
+	if msg.ID == "" {
+		return false
+	}
+
+	c.mu.Lock()
+	replyChan, exists := c.pending[msg.ID]
+	delete(c.pending, msg.ID)
+	c.mu.Unlock()
+
+	if !exists {
+		return false
+	}
+	replyChan <- msg
+	return true
+}
+
+// groups returns a copy of the last group state reported by the client.
+func (c *homeClient) groups() service.Groups {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(service.Groups(nil), c.groupsState...)
+}
+
+// setGroups replaces the group state reported by the client.
+func (c *homeClient) setGroups(groups service.Groups) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.groupsState = groups
+}
+
+// sceneList returns a copy of the scenes last reported by the client.
+func (c *homeClient) sceneList() service.Scenes {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(service.Scenes(nil), c.scenes...)
+}
+
+// setScenes replaces the scenes reported by the client.
+func (c *homeClient) setScenes(scenes service.Scenes) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scenes = scenes
+}
+
+// updateGroup replaces the state of the group with the same name as g. The group
+// keeps its reachability when g doesn't know it.
+func (c *homeClient) updateGroup(g service.Group) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.groupsState {
+		if c.groupsState[i].Name == g.Name {
+			if g.Reachable == nil {
+				g.Reachable = c.groupsState[i].Reachable
+			}
+			c.groupsState[i] = g
+			return
+		}
+	}
+	c.groupsState = append(c.groupsState, g)
+}
+
+// clientGroup ties a group to the home client that owns it. The embedded group is
+// named for display; bridgeName is the name the client knows it by.
+type clientGroup struct {
+	client     *homeClient
+	bridgeName string
+	service.Group
+}
+
+// clientRegistry holds every known home client keyed by client ID.
+type clientRegistry struct {
+	mu      sync.RWMutex
+	clients map[string]*homeClient
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{clients: make(map[string]*homeClient)}
+}
+
+// add registers c and returns the client it replaced, if any. c starts with the last
+// known state of the client it replaces until it reports its own.
+func (reg *clientRegistry) add(c *homeClient) *homeClient {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	old := reg.clients[c.id]
+	if old != nil {
+		st := old.state()
+		c.setGroups(st.Groups)
+		c.setScenes(st.Scenes)
+	}
+	reg.clients[c.id] = c
+	return old
+}
+
+// restore registers offline clients from previously persisted state.
+func (reg *clientRegistry) restore(states []service.ClientState) {
+	for _, st := range states {
+		c := newHomeClient(st.ClientID, nil)
+		c.setGroups(st.Groups)
+		c.setScenes(st.Scenes)
+		reg.add(c)
+	}
+}
+
+// remove marks c offline unless it has already been replaced by a newer connection.
+func (reg *clientRegistry) remove(c *homeClient) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.clients[c.id] == c {
+		c.disconnect()
+	}
+}
+
+// connected returns the clients that currently have a connection, ordered by ID.
+func (reg *clientRegistry) connected() []*homeClient {
+	var clients []*homeClient
+	for _, c := range reg.all() {
+		if c.connected() {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// all returns every known client, connected or not, ordered by ID.
+func (reg *clientRegistry) all() []*homeClient {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	clients := make([]*homeClient, 0, len(reg.clients))
+	for _, c := range reg.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// groups returns the groups of every client. Names shared by more than one client are
+// qualified with the client ID, e.g. "Cabin/Kitchen", so they stay unambiguous.
+func (reg *clientRegistry) groups() []clientGroup {
+	clients := reg.all()
+
+	owners := make(map[string]int)
+	for _, c := range clients {
+		seen := make(map[string]bool)
+		for _, g := range c.groups() {
+			key := strings.ToLower(g.Name)
+			if !seen[key] {
+				seen[key] = true
+				owners[key]++
+			}
+		}
+	}
+
+	var groups []clientGroup
+	for _, c := range clients {
+		for _, g := range c.groups() {
+			cg := clientGroup{client: c, bridgeName: g.Name, Group: g}
+			if owners[strings.ToLower(g.Name)] > 1 {
+				cg.Name = fmt.Sprintf("%s/%s", c.id, g.Name)
+			}
+			groups = append(groups, cg)
+		}
+	}
+	return groups
+}
+
+// displayGroups returns the groups of every client under their display names.
+func (reg *clientRegistry) displayGroups() service.Groups {
+	var groups service.Groups
+	for _, cg := range reg.groups() {
+		groups = append(groups, cg.Group)
+	}
+	return groups
+}
+
+// displayScenes returns the scenes of every client, named by the display name of their group.
+func (reg *clientRegistry) displayScenes() service.Scenes {
+	var scenes service.Scenes
+	for _, cg := range reg.groups() {
+		for _, sc := range cg.scenes() {
+			sc.Group = cg.Name
+			scenes = append(scenes, sc)
+		}
+	}
+	return scenes
+}
+
+// scenes returns the scenes the client reported for the group.
+func (cg clientGroup) scenes() service.Scenes {
+	return cg.client.sceneList().ForGroup(cg.bridgeName)
+}
+
+// displayName returns the display name of the client's group with the given bridge name.
+func (reg *clientRegistry) displayName(c *homeClient, bridgeName string) string {
+	for _, cg := range reg.groups() {
+		if cg.client == c && cg.bridgeName == bridgeName {
+			return cg.Name
+		}
+	}
+	return bridgeName
+}
+
+// lookupGroup finds the group with the given display name. A bare name also matches
+// a qualified one as long as only a single client owns a group by that name.
+func (reg *clientRegistry) lookupGroup(name string) (clientGroup, bool) {
+	var matches []clientGroup
+	for _, cg := range reg.groups() {
+		if strings.EqualFold(cg.Name, name) {
+			return cg, true
+		}
+		if strings.EqualFold(cg.bridgeName, name) {
+			matches = append(matches, cg)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], true
+	}
+	return clientGroup{}, false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// connectedTestClient returns a home client connected through a test server, along
+// with the home side of the connection the requests arrive on.
+func connectedTestClient(t *testing.T) (*homeClient, *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	home, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { home.Close() })
+
+	c := newHomeClient("home", <-conns)
+	t.Cleanup(c.disconnect)
+	return c, home
+}
+
+// readRequest reads the next request the home side receives.
+func readRequest(t *testing.T, home *websocket.Conn) JSONMessage {
+	t.Helper()
+
+	var msg JSONMessage
+	if err := home.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID == "" {
+		t.Fatalf("got request %+v without an ID", msg)
+	}
+	return msg
+}
+
+// pendingCount returns the number of requests awaiting a reply.
+func pendingCount(c *homeClient) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+func TestHomeClientRequestReply(t *testing.T) {
+	c, home := connectedTestClient(t)
+
+	type result struct {
+		reply JSONMessage
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		reply, err := c.request(JSONMessage{Type: "get_groups"}, time.Second)
+		results <- result{reply, err}
+	}()
+
+	req := readRequest(t, home)
+	if req.Type != "get_groups" {
+		t.Errorf("got request type %q, want get_groups", req.Type)
+	}
+
+	// A reply to some other request is not delivered
+	if c.resolve(JSONMessage{ID: "other", Type: "group_state"}) {
+		t.Error("resolved a reply to an unknown request")
+	}
+	if !c.resolve(JSONMessage{ID: req.ID, Type: "group_state"}) {
+		t.Fatal("the reply did not resolve the request")
+	}
+
+	res := <-results
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.reply.ID != req.ID || res.reply.Type != "group_state" {
+		t.Errorf("got reply %+v, want the group_state reply to %s", res.reply, req.ID)
+	}
+	if n := pendingCount(c); n != 0 {
+		t.Errorf("got %d pending requests after the reply, want 0", n)
+	}
+}
+
+func TestHomeClientRequestTimeout(t *testing.T) {
+	c, home := connectedTestClient(t)
+
+	_, err := c.request(JSONMessage{Type: "update"}, 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if n := pendingCount(c); n != 0 {
+		t.Errorf("got %d pending requests after the timeout, want 0", n)
+	}
+
+	// A reply arriving after the timeout is ignored without blocking the read loop
+	req := readRequest(t, home)
+	resolved := make(chan bool, 1)
+	go func() { resolved <- c.resolve(JSONMessage{ID: req.ID, Type: "update_result"}) }()
+	select {
+	case ok := <-resolved:
+		if ok {
+			t.Error("a late reply resolved a request that had timed out")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resolving a late reply blocked")
+	}
+}
+
+func TestHomeClientDuplicateReply(t *testing.T) {
+	c, home := connectedTestClient(t)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.request(JSONMessage{Type: "update"}, time.Second)
+		done <- err
+	}()
+	req := readRequest(t, home)
+
+	// Only the first of several replies with the same ID is delivered, and the
+	// duplicates neither block nor panic
+	resolved := make(chan int, 1)
+	go func() {
+		n := 0
+		for i := 0; i < 3; i++ {
+			if c.resolve(JSONMessage{ID: req.ID, Type: "update_result"}) {
+				n++
+			}
+		}
+		resolved <- n
+	}()
+	select {
+	case n := <-resolved:
+		if n != 1 {
+			t.Errorf("resolved %d replies, want 1", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resolving duplicate replies blocked")
+	}
+
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHomeClientRequestOffline(t *testing.T) {
+	c := newHomeClient("home", nil)
+
+	if _, err := c.request(JSONMessage{Type: "get_groups"}, time.Second); !errors.Is(err, errClientOffline) {
+		t.Errorf("got %v, want errClientOffline", err)
+	}
+	if n := pendingCount(c); n != 0 {
+		t.Errorf("got %d pending requests, want 0", n)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// pendingConfirmation is a bulk or sensitive request held until the sender confirms it.
+type pendingConfirmation struct {
+	Text    string
+	Actions []service.Intent
+}
+
+// Replies that confirm or cancel a pending request.
+var (
+	confirmWords = []string{"yes", "y", "confirm"}
+	cancelWords  = []string{"no", "n", "cancel"}
+)
+
+// timeWindow is a daily span of time such as 22:00-07:00, which may wrap past midnight.
+type timeWindow struct {
+	start, end int // minutes after midnight
+}
+
+// parseTimeWindow parses a window written as "HH:MM-HH:MM".
+func parseTimeWindow(s string) (timeWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return timeWindow{}, fmt.Errorf("time window %q must look like 22:00-07:00", s)
+	}
+
+	var w timeWindow
+	for _, part := range []struct {
+		text string
+		dst  *int
+	}{{from, &w.start}, {to, &w.end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part.text))
+		if err != nil {
+			return timeWindow{}, fmt.Errorf("time window %q must look like 22:00-07:00", s)
+		}
+		*part.dst = t.Hour()*60 + t.Minute()
+	}
+	return w, nil
+}
+
+// contains reports whether t falls within the window. The zero window is all day.
+func (w timeWindow) contains(t time.Time) bool {
+	if w.start == w.end {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// confirmationPrompt returns the question to ask before running actions that change
+// many groups at once or turn off a sensitive group during its sensitive hours. The
+// actions schedules and rules run later count too: a schedule is checked against the
+// hours it runs at, and since a rule keeps running at times nobody reviews, turning
+// off a sensitive group from one always asks. ok is false when the actions can run
+// right away.
+func (app *application) confirmationPrompt(actions []service.Intent, groups service.Groups, now time.Time) (prompt string, ok bool) {
+	affected := make(map[string]bool)
+	var order []string
+	ons, offs := 0, 0
+	var sensitive []string
+
+	add := func(name string) {
+		if !affected[strings.ToLower(name)] {
+			affected[strings.ToLower(name)] = true
+			order = append(order, name)
+		}
+	}
+
+	var visit func(actions []service.Intent, at time.Time, recurring bool)
+	visit = func(actions []service.Intent, at time.Time, recurring bool) {
+		for _, action := range actions {
+			switch data := action.Data.(type) {
+			case GPTUpdateRequest:
+				targets := []string{data.Group}
+				if strings.EqualFold(data.Group, allGroupsTarget) {
+					targets = groups.Names()
+				}
+				for _, name := range targets {
+					add(name)
+					if !data.IsOn && containsFold(app.config.confirm.sensitiveGroups, name) &&
+						(recurring || app.config.confirm.sensitiveHours.contains(at)) {
+						sensitive = append(sensitive, name)
+					}
+				}
+				if data.IsOn {
+					ons++
+				} else {
+					offs++
+				}
+
+			case GPTSceneRequest:
+				add(data.Group)
+				ons++
+
+			case GPTScheduleRequest:
+				runAt, err := data.runAt(now)
+				if err != nil {
+					runAt = at
+				}
+				visit(data.Actions, runAt, recurring)
+
+			case GPTRuleRequest:
+				visit(data.Actions, at, true)
+			}
+		}
+	}
+	visit(actions, now, false)
+
+	minGroups := app.config.confirm.minGroups
+	bulk := minGroups > 0 && len(affected) >= minGroups
+	if !bulk && len(sensitive) == 0 {
+		return "", false
+	}
+
+	verb := "Change"
+	switch {
+	case offs > 0 && ons == 0:
+		verb = "Turn off"
+	case ons > 0 && offs == 0:
+		verb = "Turn on"
+	}
+
+	if bulk {
+		return fmt.Sprintf("%s %d groups? Reply YES to confirm.", verb, len(affected)), true
+	}
+	return fmt.Sprintf("%s %s? Reply YES to confirm.", verb, strings.Join(order, ", ")), true
+}
+
+// holdForConfirmation stores the actions until the user confirms them.
+func (app *application) holdForConfirmation(user service.User, text string, actions []service.Intent, now time.Time) {
+	app.confirmations.Put(user.Phone, pendingConfirmation{Text: text, Actions: actions}, now)
+}
+
+// answerConfirmation handles the user's reply to a pending confirmation. Any text
+// drops the pending request, and only a YES from the same user runs it. ok is false
+// when the text wasn't a reply to a confirmation and should be processed as usual.
+func (app *application) answerConfirmation(user service.User, text string) (reply string, ok bool) {
+	answer := strings.ToLower(strings.Trim(strings.TrimSpace(text), ".!"))
+	isConfirm := containsFold(confirmWords, answer)
+	isCancel := containsFold(cancelWords, answer)
+
+	pending, found, expired := app.confirmations.Take(user.Phone, time.Now())
+	switch {
+	case expired && (isConfirm || isCancel):
+		return "That request expired. Please send it again.", true
+	case !found && isConfirm:
+		return "There's nothing waiting for confirmation.", true
+	case !found:
+		return "", false
+	case isConfirm:
+		app.logger.Info("confirmed pending request", "from", user.Phone, "text", pending.Text)
+		return app.executeActions(user, pending.Actions), true
+	case isCancel:
+		return "Okay, I won't do that.", true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	"github.com/amimof/huego"
+)
+
+func TestConfirmationPrompt(t *testing.T) {
+	var groups service.Groups
+	for _, name := range []string{"Kitchen", "Office", "Bedroom", "Nursery", "Porch"} {
+		groups = append(groups, service.Group{Group: huego.Group{Name: name}})
+	}
+
+	app := &application{}
+	app.config.confirm.minGroups = 3
+	app.config.confirm.sensitiveGroups = []string{"Nursery"}
+	app.config.confirm.sensitiveHours = timeWindow{start: 20 * 60, end: 7 * 60}
+
+	day := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+	night := time.Date(2026, 10, 18, 22, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		actions string
+		now     time.Time
+		want    string
+	}{
+		{
+			name:    "single group",
+			actions: `{"type":"update","data":{"group":"Kitchen","isOn":false}}`,
+			now:     day,
+		},
+		{
+			name:    "every group",
+			actions: `{"type":"update","data":{"group":"all","isOn":false}}`,
+			now:     day,
+			want:    "Turn off 5 groups? Reply YES to confirm.",
+		},
+		{
+			name: "many groups",
+			actions: `[{"type":"update","data":{"group":"Kitchen","isOn":true}},
+				{"type":"update","data":{"group":"Office","isOn":true}},
+				{"type":"scene","data":{"group":"Bedroom","scene":"Relax"}}]`,
+			now:  day,
+			want: "Turn on 3 groups? Reply YES to confirm.",
+		},
+		{
+			name:    "sensitive group by day",
+			actions: `{"type":"update","data":{"group":"Nursery","isOn":false}}`,
+			now:     day,
+		},
+		{
+			name:    "sensitive group at night",
+			actions: `{"type":"update","data":{"group":"Nursery","isOn":false}}`,
+			now:     night,
+			want:    "Turn off Nursery? Reply YES to confirm.",
+		},
+		{
+			name: "scheduled bulk change",
+			actions: `{"type":"schedule","data":{"in":"1h","description":"Lights out","actions":[
+				{"type":"update","data":{"group":"all","isOn":false}}]}}`,
+			now:  day,
+			want: "Turn off 5 groups? Reply YES to confirm.",
+		},
+		{
+			name: "scheduled into sensitive hours",
+			actions: `{"type":"schedule","data":{"in":"9h","description":"Nursery off","actions":[
+				{"type":"update","data":{"group":"Nursery","isOn":false}}]}}`,
+			now:  day,
+			want: "Turn off Nursery? Reply YES to confirm.",
+		},
+		{
+			name: "scheduled out of sensitive hours",
+			actions: `{"type":"schedule","data":{"in":"10h","description":"Nursery off","actions":[
+				{"type":"update","data":{"group":"Nursery","isOn":false}}]}}`,
+			now: night,
+		},
+		{
+			name: "rule into sensitive hours created by day",
+			actions: `{"type":"create_rule","data":{"cron":"0 23 * * *","description":"Nursery off","actions":[
+				{"type":"update","data":{"group":"Nursery","isOn":false}}]}}`,
+			now:  day,
+			want: "Turn off Nursery? Reply YES to confirm.",
+		},
+		{
+			name: "rule turning on a sensitive group",
+			actions: `{"type":"create_rule","data":{"cron":"0 23 * * *","description":"Nursery on","actions":[
+				{"type":"update","data":{"group":"Nursery","isOn":true}}]}}`,
+			now: day,
+		},
+		{
+			name: "rule over many groups",
+			actions: `{"type":"create_rule","data":{"cron":"0 23 * * *","description":"Night","actions":[
+				{"type":"update","data":{"group":"Kitchen","isOn":false}},
+				{"type":"update","data":{"group":"Office","isOn":false}},
+				{"type":"update","data":{"group":"Porch","isOn":false}}]}}`,
+			now:  day,
+			want: "Turn off 3 groups? Reply YES to confirm.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := parseActions(tt.actions)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prompt, ok := app.confirmationPrompt(actions, groups, tt.now)
+			if ok != (tt.want != "") || prompt != tt.want {
+				t.Errorf("got %q, %t; want %q", prompt, ok, tt.want)
+			}
+		})
+	}
+}
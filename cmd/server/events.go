@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventBufferSize is how many events a subscriber may fall behind before it is dropped.
+const eventBufferSize = 64
+
+// eventHeartbeat is how often an idle event stream is sent a comment to keep it open.
+const eventHeartbeat = 15 * time.Second
+
+// Event types published to /v1/events subscribers.
+const (
+	eventGroupState         = "group_state"
+	eventCommand            = "command"
+	eventClientConnected    = "client_connected"
+	eventClientDisconnected = "client_disconnected"
+	eventError              = "error"
+)
+
+// event is a single message published to /v1/events subscribers.
+type event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// eventSubscriber receives published events on a buffered channel. The channel is
+// closed when the subscriber unsubscribes or is dropped for falling behind.
+type eventSubscriber struct {
+	events chan event
+}
+
+// eventBroker fans published events out to every subscriber.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: make(map[*eventSubscriber]struct{})}
+}
+
+// subscribe registers a new subscriber.
+func (b *eventBroker) subscribe() *eventSubscriber {
+	sub := &eventSubscriber{events: make(chan event, eventBufferSize)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes the subscriber and closes its channel if it is still registered.
+func (b *eventBroker) unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publish sends an event to every subscriber without blocking. A subscriber whose
+// buffer is full is dropped, so one slow consumer never holds up the rest.
+func (b *eventBroker) publish(eventType string, data any) {
+	e := event{Type: eventType, Time: time.Now(), Data: data}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- e:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// eventsHandler streams published events to the client as Server-Sent Events until
+// the client disconnects or falls too far behind.
+func (app *application) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	// The stream outlives the server's write timeout.
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sub := app.events.subscribe()
+	defer app.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+
+		case e, ok := <-sub.events:
+			if !ok {
+				app.logger.Warn("dropped slow event subscriber", "remote_addr", r.RemoteAddr)
+				return
+			}
+
+			js, err := json.Marshal(e)
+			if err != nil {
+				app.logError(r, err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, js)
+			if err != nil {
+				return
+			}
+		}
+
+		err := rc.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBrokerDropsSlowSubscriber(t *testing.T) {
+	b := newEventBroker()
+	slow := b.subscribe()
+	fast := b.subscribe()
+
+	// The fast subscriber reads every event as it comes, while the slow one never
+	// reads and its buffer fills after eventBufferSize events
+	published := make(chan int)
+	go func() {
+		received := 0
+		for i := 0; i < eventBufferSize*2; i++ {
+			b.publish(eventCommand, i)
+			if _, ok := <-fast.events; ok {
+				received++
+			}
+		}
+		published <- received
+	}()
+
+	select {
+	case received := <-published:
+		if received != eventBufferSize*2 {
+			t.Errorf("fast subscriber got %d events, want %d", received, eventBufferSize*2)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on the slow subscriber")
+	}
+
+	// The slow subscriber got what fit in its buffer, then its channel was closed
+	n := 0
+	for range slow.events {
+		n++
+	}
+	if n != eventBufferSize {
+		t.Errorf("slow subscriber got %d events, want %d", n, eventBufferSize)
+	}
+
+	// Unsubscribing a dropped subscriber is harmless
+	b.unsubscribe(slow)
+}
+
+func TestEventsHandlerToken(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.config.auth_token = "client-secret"
+	app.config.apiToken = "api-secret"
+
+	srv := httptest.NewServer(app.routes())
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name          string
+		query         string
+		authorization string
+		wantStatus    int
+	}{
+		{"query token", "?token=api-secret", "", http.StatusOK},
+		{"header token", "", "Bearer api-secret", http.StatusOK},
+		{"wrong query token", "?token=nope", "Bearer api-secret", http.StatusUnauthorized},
+		{"client token in query", "?token=client-secret", "", http.StatusUnauthorized},
+		{"no token", "", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/events"+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if res.StatusCode != http.StatusOK {
+				return
+			}
+
+			// The stream is subscribed once its headers arrive
+			app.events.publish(eventClientConnected, map[string]string{"clientId": "home"})
+
+			lines := bufio.NewScanner(res.Body)
+			for lines.Scan() {
+				if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+					if !strings.Contains(data, `"clientId":"home"`) {
+						t.Errorf("got event %s, want the client connecting", data)
+					}
+					return
+				}
+			}
+			t.Errorf("stream ended without an event: %v", lines.Err())
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	"github.com/julienschmidt/httprouter"
+)
+
+// groupResponse is the JSON representation of a group in the REST API.
+type groupResponse struct {
+	ClientID string `json:"clientId"`
+	Online   bool   `json:"online"`
+	service.Group
+}
+
+func newGroupResponse(cg clientGroup) groupResponse {
+	return groupResponse{
+		ClientID: cg.client.id,
+		Online:   cg.client.connected(),
+		Group:    cg.Group,
+	}
+}
+
+// groupFromRequest finds the group named in the URL. Since group names can't contain
+// a slash in a URL, a qualified name such as "Cabin/Kitchen" is given as the name plus
+// a client query parameter.
+func (app *application) groupFromRequest(r *http.Request) (clientGroup, bool) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+	if client := r.URL.Query().Get("client"); client != "" {
+		name = fmt.Sprintf("%s/%s", client, name)
+	}
+	return app.clients.lookupGroup(name)
+}
+
+// listGroupsHandler returns every group. With refresh=true it first asks the connected
+// clients for their current state.
+func (app *application) listGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("refresh") == "true" {
+		err := app.SetGroupsStateField()
+		if err != nil && !errors.Is(err, errNoClients) {
+			app.logError(r, err)
+		}
+	}
+
+	groups := []groupResponse{}
+	for _, cg := range app.clients.groups() {
+		groups = append(groups, newGroupResponse(cg))
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"groups": groups}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showGroupHandler returns a single group.
+func (app *application) showGroupHandler(w http.ResponseWriter, r *http.Request) {
+	cg, ok := app.groupFromRequest(r)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"group": newGroupResponse(cg)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateGroupStateHandler applies a JSON body shaped like GPTUpdateRequest to the group
+// named in the URL, through the same path as an SMS update.
+func (app *application) updateGroupStateHandler(w http.ResponseWriter, r *http.Request) {
+	var input GPTUpdateRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+	if strings.EqualFold(name, allGroupsTarget) {
+		input.Group = allGroupsTarget
+	} else {
+		cg, ok := app.groupFromRequest(r)
+		if !ok {
+			app.notFoundResponse(w, r)
+			return
+		}
+		input.Group = cg.Name
+
+		// Check the request before it reaches the client
+		_, err = newClientUpdateData(input, cg.bridgeName)
+		if err != nil {
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+
+	err = app.validateUpdate(&input, app.clients.displayGroups())
+	if err != nil {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	reply, err := app.executeUpdate(input)
+	if err != nil {
+		app.logError(r, err)
+		app.errorResponse(w, r, http.StatusBadGateway, reply)
+		return
+	}
+
+	env := envelope{"result": reply}
+	if cg, ok := app.clients.lookupGroup(input.Group); ok {
+		env["group"] = newGroupResponse(cg)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createCommandHandler runs natural-language text through the same pipeline as an SMS.
+func (app *application) createCommandHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Text string `json:"text"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if strings.TrimSpace(input.Text) == "" {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "text must be provided")
+		return
+	}
+
+	app.recordHistory(service.HistoryInboundMessage, "api", "", input.Text, nil)
+
+	reply, err := app.processText(apiUser, input.Text)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reply": reply}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
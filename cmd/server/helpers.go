@@ -1,8 +1,19 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json" // New import
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strings"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
 )
 
 type envelope map[string]any
@@ -27,8 +38,51 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// readJSON decodes a single JSON value from the request body into dst, rejecting
+// unknown fields and bodies larger than 1MB.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.As(err, &unmarshalTypeError):
+			if unmarshalTypeError.Field != "" {
+				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+			}
+			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("body contains unknown key %s", fieldName)
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	err = dec.Decode(&struct{}{})
+	if !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
 // JSONMessage represents the structure of the incoming WebSocket messages.
 type JSONMessage struct {
+	ID   string      `json:"id,omitempty"`
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
 }
@@ -42,3 +96,85 @@ func (m *JSONMessage) UnmarshalJSON(data []byte) error {
 	}
 	return json.Unmarshal(data, &aux)
 }
+
+// decodeMessageData decodes the generic Data field of msg into dst.
+func decodeMessageData(msg JSONMessage, dst any) error {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", false
+	}
+
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// validAuthToken compares token against the configured home client token in constant time.
+func (app *application) validAuthToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(app.config.auth_token)) == 1
+}
+
+// validAPIToken compares token against the configured REST API token in constant time.
+// No token is valid while the API token is unset.
+func (app *application) validAPIToken(token string) bool {
+	if app.config.apiToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(app.config.apiToken)) == 1
+}
+
+// newMessageID returns a random ID used to correlate a request with its reply.
+func newMessageID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// parseActions decodes a GPT response holding either a single action object or an
+// array of actions to run in order.
+func parseActions(gptResponse string) ([]service.Intent, error) {
+	return intentSchemas().Parse(gptResponse)
+}
+
+// setFlagsFromEnv sets each flag of fs in envVars that wasn't given on the command
+// line from the environment variable it maps to, when that variable is set.
+func setFlagsFromEnv(fs *flag.FlagSet, envVars map[string]string) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for name, envVar := range envVars {
+		value, ok := os.LookupEnv(envVar)
+		if given[name] || !ok || value == "" {
+			continue
+		}
+		err := fs.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", envVar, err)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, trimming space and dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// testOwner is the address of the configured owner in applications from newTestApp.
+const testOwner = "fake:owner"
+
+// newTestApp returns an application backed by a temporary data directory, whose
+// messages are recorded by the returned FakeTransport.
+func newTestApp(t *testing.T) (*application, *service.FakeTransport) {
+	t.Helper()
+
+	dir := t.TempDir()
+	store, err := service.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	users, err := service.NewUsers(filepath.Join(dir, "users.json"), service.User{Phone: testOwner, Name: "Owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler, err := service.NewScheduler(filepath.Join(dir, "schedules.json"), func(service.Schedule) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := service.NewRules(filepath.Join(dir, "rules.json"), service.Coordinates{}, func(service.Rule) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &service.FakeTransport{}
+	app := &application{
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		transports:    map[string]service.Transport{service.TransportFake: fake},
+		conversations: service.NewConversations(5, 10*time.Minute),
+		questions:     service.NewPending[pendingQuestion](5 * time.Minute),
+		confirmations: service.NewPending[pendingConfirmation](2 * time.Minute),
+		clients:       newClientRegistry(),
+		scheduler:     scheduler,
+		rules:         rules,
+		store:         store,
+		users:         users,
+		events:        newEventBroker(),
+	}
+	app.config.userPhoneNumber = testOwner
+	return app, fake
+}
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	envVars := map[string]string{
+		"openaiModel":       "TEST_OPENAI_MODEL",
+		"openaiTemperature": "TEST_OPENAI_TEMPERATURE",
+		"openaiMaxTokens":   "TEST_OPENAI_MAX_TOKENS",
+		"openaiTimeout":     "TEST_OPENAI_TIMEOUT",
+	}
+
+	newFlags := func() (*flag.FlagSet, *config) {
+		var cfg config
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.StringVar(&cfg.openai.model, "openaiModel", "gpt-4o", "")
+		fs.Float64Var(&cfg.openai.temperature, "openaiTemperature", -1, "")
+		fs.IntVar(&cfg.openai.maxTokens, "openaiMaxTokens", 0, "")
+		fs.DurationVar(&cfg.openai.timeout, "openaiTimeout", 30*time.Second, "")
+		return fs, &cfg
+	}
+
+	t.Run("command line wins", func(t *testing.T) {
+		t.Setenv("TEST_OPENAI_MODEL", "env-model")
+		t.Setenv("TEST_OPENAI_TEMPERATURE", "0.7")
+
+		fs, cfg := newFlags()
+		if err := fs.Parse([]string{"-openaiModel", "cli-model"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := setFlagsFromEnv(fs, envVars); err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.openai.model != "cli-model" {
+			t.Errorf("got model %q, want the command line's", cfg.openai.model)
+		}
+		if cfg.openai.temperature != 0.7 {
+			t.Errorf("got temperature %v, want the environment's", cfg.openai.temperature)
+		}
+	})
+
+	t.Run("unset and empty variables keep the defaults", func(t *testing.T) {
+		t.Setenv("TEST_OPENAI_MAX_TOKENS", "")
+
+		fs, cfg := newFlags()
+		if err := fs.Parse(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := setFlagsFromEnv(fs, envVars); err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.openai.model != "gpt-4o" || cfg.openai.temperature != -1 || cfg.openai.maxTokens != 0 || cfg.openai.timeout != 30*time.Second {
+			t.Errorf("got %+v, want the defaults", cfg.openai)
+		}
+	})
+
+	t.Run("environment fills every flag", func(t *testing.T) {
+		t.Setenv("TEST_OPENAI_MODEL", "env-model")
+		t.Setenv("TEST_OPENAI_TEMPERATURE", "0")
+		t.Setenv("TEST_OPENAI_MAX_TOKENS", "500")
+		t.Setenv("TEST_OPENAI_TIMEOUT", "5s")
+
+		fs, cfg := newFlags()
+		if err := fs.Parse(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := setFlagsFromEnv(fs, envVars); err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.openai.model != "env-model" || cfg.openai.temperature != 0 || cfg.openai.maxTokens != 500 || cfg.openai.timeout != 5*time.Second {
+			t.Errorf("got %+v, want the environment's values", cfg.openai)
+		}
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		t.Setenv("TEST_OPENAI_TIMEOUT", "soon")
+
+		fs, _ := newFlags()
+		if err := fs.Parse(nil); err != nil {
+			t.Fatal(err)
+		}
+		err := setFlagsFromEnv(fs, envVars)
+		if err == nil || !strings.Contains(err.Error(), "TEST_OPENAI_TIMEOUT") {
+			t.Errorf("got %v, want an error naming the variable", err)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// recordHistory appends an entry to the audit history. data, when not nil, is stored
+// as JSON. Failures are logged rather than returned so history never blocks a command.
+func (app *application) recordHistory(kind, sender, clientID, text string, data any) {
+	entry := service.HistoryEntry{
+		Kind:     kind,
+		Sender:   sender,
+		ClientID: clientID,
+		Text:     text,
+	}
+
+	if data != nil {
+		js, err := json.Marshal(data)
+		if err != nil {
+			app.logger.Error("error encoding history entry", "kind", kind, "error", err)
+		}
+		entry.Data = js
+	}
+
+	err := app.store.Record(entry)
+	if err != nil {
+		app.logger.Error("error recording history entry", "kind", kind, "error", err)
+	}
+}
+
+// historyHandler returns the most recent history entries. The limit query parameter
+// sets how many, 100 by default.
+func (app *application) historyHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	history, err := app.store.History(limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"history": history}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
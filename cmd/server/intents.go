@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+// Modes of the local grammar parser, set with the localIntents flag.
+const (
+	localIntentsOff      = "off"      // only OpenAI
+	localIntentsPrimary  = "primary"  // only the grammar, OpenAI is never called
+	localIntentsFallback = "fallback" // the grammar when OpenAI fails
+	localIntentsFastPath = "fastpath" // the grammar first, OpenAI for anything it doesn't understand
+)
+
+// intentSchemas lists the actions OpenAI may call, described by the request types the
+// actions are decoded into.
+func intentSchemas() service.IntentSchemas {
+	return service.IntentSchemas{
+		{Name: "status", Description: "Report the state of groups.", Data: GPTStatusData{}},
+		{Name: "update", Description: "Turn a group on or off or change its brightness, color or effect.", Data: GPTUpdateRequest{}},
+		{Name: "scene", Description: "Recall a scene in a group.", Data: GPTSceneRequest{}},
+		{Name: "schedule", Description: "Run update or scene actions once at a later time.", Data: GPTScheduleRequest{}},
+		{Name: "list_schedules", Description: "List the pending schedules."},
+		{Name: "cancel_schedule", Description: "Cancel a pending schedule.", Data: GPTCancelScheduleRequest{}},
+		{Name: "create_rule", Description: "Run update or scene actions on a recurring schedule.", Data: GPTRuleRequest{}},
+		{Name: "list_rules", Description: "List the recurring rules."},
+		{Name: "pause_rule", Description: "Pause a recurring rule.", Data: GPTRuleIDRequest{}},
+		{Name: "resume_rule", Description: "Resume a paused rule.", Data: GPTRuleIDRequest{}},
+		{Name: "delete_rule", Description: "Delete a recurring rule.", Data: GPTRuleIDRequest{}},
+		{Name: "clarify", Description: "Ask a question when the request is ambiguous instead of guessing.", Data: GPTClarifyRequest{}},
+	}
+}
+
+// nestedActions are the actions a schedule or rule runs, decoded like the actions of
+// a message.
+type nestedActions []service.Intent
+
+func (a *nestedActions) UnmarshalJSON(data []byte) error {
+	intents, err := parseActions(string(data))
+	if err != nil {
+		return err
+	}
+	*a = intents
+	return nil
+}
+
+// actionData returns the decoded data of action, which must be a T.
+func actionData[T any](action service.Intent) (T, error) {
+	data, ok := action.Data.(T)
+	if !ok {
+		return data, fmt.Errorf("%s action holds %T, not %T", action.Type, action.Data, data)
+	}
+	return data, nil
+}
+
+// nestedActionsOf returns the actions a schedule or create_rule action runs.
+func nestedActionsOf(action service.Intent) ([]service.Intent, error) {
+	switch data := action.Data.(type) {
+	case GPTScheduleRequest:
+		return data.Actions, nil
+	case GPTRuleRequest:
+		return data.Actions, nil
+	default:
+		return nil, fmt.Errorf("%s action holds %T, not nested actions", action.Type, action.Data)
+	}
+}
+
+// newOpenaiService returns the OpenAI intent parser for the configured server, model
+// and parameters.
+func newOpenaiService(cfg config, apiKey string) *service.OpenaiService {
+	openaiConfig := goopenai.DefaultConfig(apiKey)
+	if cfg.openai.baseURL != "" {
+		openaiConfig.BaseURL = cfg.openai.baseURL
+	}
+	openaiConfig.OrgID = cfg.openai.organization
+
+	openaiService := &service.OpenaiService{
+		Client:    goopenai.NewClientWithConfig(openaiConfig),
+		Model:     cfg.openai.model,
+		MaxTokens: cfg.openai.maxTokens,
+		Timeout:   cfg.openai.timeout,
+		Intents:   intentSchemas(),
+	}
+	if cfg.openai.temperature >= 0 {
+		temperature := float32(cfg.openai.temperature)
+		openaiService.Temperature = &temperature
+	}
+	return openaiService
+}
+
+// newIntentParser combines OpenAI and the local grammar according to mode.
+func newIntentParser(mode string, openai *service.OpenaiService) (service.IntentParser, error) {
+	grammar := service.GrammarParser{Intents: intentSchemas()}
+	switch mode {
+	case localIntentsOff:
+		return openai, nil
+	case localIntentsPrimary:
+		return grammar, nil
+	case localIntentsFallback:
+		return service.IntentParsers{openai, grammar}, nil
+	case localIntentsFastPath:
+		return service.IntentParsers{grammar, openai}, nil
+	default:
+		return nil, fmt.Errorf("unknown localIntents mode %q", mode)
+	}
+}
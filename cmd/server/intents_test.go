@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	"github.com/amimof/huego"
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+// stubChatCompletions returns a server answering chat completions by turning the
+// kitchen off, after passing each request to inspect.
+func stubChatCompletions(t *testing.T, inspect func(r *http.Request, body map[string]any)) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+			return
+		}
+		inspect(r, body)
+
+		json.NewEncoder(w).Encode(goopenai.ChatCompletionResponse{
+			Choices: []goopenai.ChatCompletionChoice{{
+				Message: goopenai.ChatCompletionMessage{
+					Role: goopenai.ChatMessageRoleAssistant,
+					ToolCalls: []goopenai.ToolCall{{
+						ID:       "call_1",
+						Type:     goopenai.ToolTypeFunction,
+						Function: goopenai.FunctionCall{Name: "update", Arguments: `{"group": "Kitchen", "isOn": false}`},
+					}},
+				},
+			}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testOpenaiRequest() service.IntentRequest {
+	return service.IntentRequest{
+		Text:   "kitchen off",
+		Groups: service.Groups{{Group: huego.Group{Name: "Kitchen"}}},
+		Now:    time.Now(),
+	}
+}
+
+func TestNewOpenaiService(t *testing.T) {
+	tests := []struct {
+		name         string
+		model        string
+		temperature  float64
+		maxTokens    int
+		organization string
+		check        func(t *testing.T, r *http.Request, body map[string]any)
+	}{
+		{
+			name:         "configured",
+			model:        "llama3.1",
+			temperature:  0.4,
+			maxTokens:    300,
+			organization: "org-home",
+			check: func(t *testing.T, r *http.Request, body map[string]any) {
+				if body["model"] != "llama3.1" {
+					t.Errorf("got model %v, want llama3.1", body["model"])
+				}
+				if got, ok := body["temperature"].(float64); !ok || math.Abs(got-0.4) > 1e-6 {
+					t.Errorf("got temperature %v, want 0.4", body["temperature"])
+				}
+				if body["max_tokens"] != 300.0 {
+					t.Errorf("got max_tokens %v, want 300", body["max_tokens"])
+				}
+				if got := r.Header.Get("OpenAI-Organization"); got != "org-home" {
+					t.Errorf("got organization %q, want org-home", got)
+				}
+			},
+		},
+		{
+			name:        "zero temperature",
+			temperature: 0,
+			check: func(t *testing.T, r *http.Request, body map[string]any) {
+				if got, ok := body["temperature"].(float64); !ok || got <= 0 || got > 1e-6 {
+					t.Errorf("got temperature %v, want the smallest one", body["temperature"])
+				}
+			},
+		},
+		{
+			name:        "defaults",
+			temperature: -1,
+			check: func(t *testing.T, r *http.Request, body map[string]any) {
+				if body["model"] != goopenai.GPT4o {
+					t.Errorf("got model %v, want %s", body["model"], goopenai.GPT4o)
+				}
+				if _, ok := body["temperature"]; ok {
+					t.Errorf("got temperature %v, want none", body["temperature"])
+				}
+				if _, ok := body["max_tokens"]; ok {
+					t.Errorf("got max_tokens %v, want none", body["max_tokens"])
+				}
+				if got := r.Header.Get("OpenAI-Organization"); got != "" {
+					t.Errorf("got organization %q, want none", got)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			srv := stubChatCompletions(t, func(r *http.Request, body map[string]any) {
+				requests++
+				if r.URL.Path != "/v1/chat/completions" {
+					t.Errorf("request to %s, want /v1/chat/completions", r.URL.Path)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+					t.Errorf("got authorization %q, want the API key", got)
+				}
+				tt.check(t, r, body)
+			})
+
+			var cfg config
+			cfg.openai.baseURL = srv.URL + "/v1"
+			cfg.openai.model = tt.model
+			cfg.openai.temperature = tt.temperature
+			cfg.openai.maxTokens = tt.maxTokens
+			cfg.openai.organization = tt.organization
+
+			intents, err := newOpenaiService(cfg, "test-key").ParseIntent(testOpenaiRequest())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requests != 1 || len(intents) != 1 || intents[0].Type != "update" {
+				t.Errorf("got %d requests and %v, want one update", requests, intents)
+			}
+		})
+	}
+}
+
+func TestNewOpenaiServiceTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	var cfg config
+	cfg.openai.baseURL = srv.URL + "/v1"
+	cfg.openai.temperature = -1
+	cfg.openai.timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := newOpenaiService(cfg, "test-key").ParseIntent(testOpenaiRequest())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline to be exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s, want the timeout to cancel the request", elapsed)
+	}
+}
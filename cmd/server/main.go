@@ -1,20 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"regexp"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/TimEngleSF/remote-hue-server/internal/service"
-	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	goopenai "github.com/sashabaranov/go-openai"
 	"github.com/twilio/twilio-go"
+	twilioValidator "github.com/twilio/twilio-go/client"
 )
 
 const version = "1.0.0"
@@ -24,19 +26,53 @@ type config struct {
 	env               string
 	userPhoneNumber   string
 	auth_token        string
+	apiToken          string
 	twilioPhoneNumber string
+	webhookURL        string
+	schedulesFile     string
+	rulesFile         string
+	dataDir           string
+	notifyGroups      []string
+	usersFile         string
+	twimlReplies      bool
+	localIntents      string
+	conversation      struct {
+		window         int
+		expiry         time.Duration
+		questionExpiry time.Duration
+	}
+	confirm struct {
+		minGroups       int
+		sensitiveGroups []string
+		sensitiveHours  timeWindow
+		expiry          time.Duration
+	}
+	openai struct {
+		baseURL      string
+		model        string
+		organization string
+		temperature  float64
+		maxTokens    int
+		timeout      time.Duration
+	}
+	location service.Coordinates
 }
 
 type application struct {
-	config       config
-	logger       *slog.Logger
-	twilio       *twilio.RestClient
-	openai       *service.OpenaiService
-	wsConnection *websocket.Conn
-	groupsState  *service.Groups
-	groupNames   service.GroupNames
-	responseMap  map[string]chan JSONMessage
-	responseMu   sync.Mutex
+	config        config
+	logger        *slog.Logger
+	transports    map[string]service.Transport
+	twilioSig     twilioValidator.RequestValidator
+	intents       service.IntentParser
+	conversations *service.Conversations
+	questions     *service.Pending[pendingQuestion]
+	confirmations *service.Pending[pendingConfirmation]
+	clients       *clientRegistry
+	scheduler     *service.Scheduler
+	rules         *service.Rules
+	store         service.Store
+	users         *service.Users
+	events        *eventBroker
 }
 
 func main() {
@@ -48,6 +84,40 @@ func main() {
 	flag.StringVar(&cfg.userPhoneNumber, "userPhoneNumber", "", "User phone number: '+19875551234'")
 	flag.StringVar(&cfg.twilioPhoneNumber, "twilioPhoneNumber", "", "Twilio phone number: '+19875551234'")
 	flag.StringVar(&cfg.auth_token, "auth_token", "password", "Authentication token for home client")
+	flag.StringVar(&cfg.apiToken, "apiToken", "", "Bearer token for the REST API, which is disabled when empty")
+	flag.StringVar(&cfg.webhookURL, "webhookURL", "", "Public URL Twilio posts to, used to verify request signatures: 'https://example.com/text'")
+	flag.BoolVar(&cfg.twimlReplies, "twimlReplies", false, "Reply to texts inline with TwiML, using the Twilio REST API only for late replies")
+	flag.StringVar(&cfg.localIntents, "localIntents", localIntentsFallback, "Use of the local grammar for common commands (off|primary|fallback|fastpath)")
+	flag.StringVar(&cfg.openai.baseURL, "openaiBaseURL", "", "Base URL of an OpenAI-compatible API, e.g. 'http://llm.lan:8080/v1' (default OpenAI)")
+	flag.StringVar(&cfg.openai.model, "openaiModel", goopenai.GPT4o, "Model used to understand text messages")
+	flag.StringVar(&cfg.openai.organization, "openaiOrganization", "", "OpenAI organization ID")
+	flag.Float64Var(&cfg.openai.temperature, "openaiTemperature", -1, "Sampling temperature, negative for the server default")
+	flag.IntVar(&cfg.openai.maxTokens, "openaiMaxTokens", 0, "Maximum tokens in a completion, 0 for the server default")
+	flag.DurationVar(&cfg.openai.timeout, "openaiTimeout", 30*time.Second, "Timeout of requests to the OpenAI API")
+	flag.IntVar(&cfg.conversation.window, "conversationWindow", 5, "Earlier texts of each sender included for follow-ups, 0 to disable")
+	flag.DurationVar(&cfg.conversation.expiry, "conversationExpiry", 10*time.Minute, "Time without texts after which a sender's conversation is forgotten")
+	flag.DurationVar(&cfg.conversation.questionExpiry, "questionExpiry", 10*time.Minute, "Time a clarifying question waits for its answer")
+	flag.IntVar(&cfg.confirm.minGroups, "confirmGroups", 5, "Ask for confirmation before changing this many groups at once, 0 to never ask")
+	flag.Func("sensitiveGroups", "Comma-separated groups that need confirmation to turn off: 'Hallway,Stairs'", func(s string) error {
+		cfg.confirm.sensitiveGroups = splitList(s)
+		return nil
+	})
+	flag.Func("sensitiveHours", "Daily hours the sensitive groups need confirmation, e.g. '22:00-07:00' (default all day)", func(s string) error {
+		var err error
+		cfg.confirm.sensitiveHours, err = parseTimeWindow(s)
+		return err
+	})
+	flag.DurationVar(&cfg.confirm.expiry, "confirmExpiry", 2*time.Minute, "Time a request waits for confirmation")
+	flag.StringVar(&cfg.schedulesFile, "schedulesFile", "schedules.json", "File pending scheduled commands are stored in")
+	flag.Func("notifyGroups", "Comma-separated groups to text about when they change unexpectedly: 'Front door,Porch'", func(s string) error {
+		cfg.notifyGroups = splitList(s)
+		return nil
+	})
+	flag.StringVar(&cfg.usersFile, "usersFile", "users.json", "File the users allowed to text the server are stored in")
+	flag.StringVar(&cfg.dataDir, "dataDir", "data", "Directory group state and command history are stored in")
+	flag.StringVar(&cfg.rulesFile, "rulesFile", "rules.json", "File recurring rules are stored in")
+	flag.Float64Var(&cfg.location.Latitude, "latitude", 0, "Latitude used for sunrise and sunset rules")
+	flag.Float64Var(&cfg.location.Longitude, "longitude", 0, "Longitude used for sunrise and sunset rules")
 	flag.BoolVar(&useEnvFile, "envFile", false, "Use .env file for environment variables")
 
 	flag.Parse()
@@ -61,13 +131,54 @@ func main() {
 		}
 	}
 
+	// The OpenAI flags not given on the command line may be set in the environment
+	err := setFlagsFromEnv(flag.CommandLine, map[string]string{
+		"openaiBaseURL":      "OPENAI_BASE_URL",
+		"openaiModel":        "OPENAI_MODEL",
+		"openaiOrganization": "OPENAI_ORG_ID",
+		"openaiTemperature":  "OPENAI_TEMPERATURE",
+		"openaiMaxTokens":    "OPENAI_MAX_TOKENS",
+		"openaiTimeout":      "OPENAI_TIMEOUT",
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// If the sensitiveGroups flag is not set, check the environment
+	if cfg.confirm.sensitiveGroups == nil {
+		cfg.confirm.sensitiveGroups = splitList(os.Getenv("SENSITIVE_GROUPS"))
+	}
+
+	// If the notifyGroups flag is not set, check the environment
+	if cfg.notifyGroups == nil {
+		cfg.notifyGroups = splitList(os.Getenv("NOTIFY_GROUPS"))
+	}
+
+	// The default auth token is only acceptable while developing locally
+	if cfg.env == "production" && cfg.auth_token == "password" {
+		logger.Error("Refusing to start in production with the default auth token")
+		os.Exit(1)
+	}
+
+	// If the apiToken flag is not set, check the environment. It must differ from the
+	// home client's token so API callers can't impersonate the client.
+	if cfg.apiToken == "" {
+		cfg.apiToken = os.Getenv("API_TOKEN")
+	}
+	switch {
+	case cfg.apiToken == "":
+		logger.Warn("The REST API is disabled until API_TOKEN is set")
+	case cfg.apiToken == cfg.auth_token:
+		logger.Error("The API token must differ from the home client auth token")
+		os.Exit(1)
+	}
+
 	// If the userPhoneNumber flag is not set, check the environment
 	if cfg.userPhoneNumber == "" {
 		cfg.userPhoneNumber = os.Getenv("USER_PHONE_NUMBER")
 		// check if phone number is the correct format '+19875551234' using regex
-
-		re := regexp.MustCompile(`^\+[1-9]\d{10,14}$`)
-		if !re.MatchString(cfg.userPhoneNumber) {
+		if !phoneNumberRX.MatchString(cfg.userPhoneNumber) {
 			logger.Error("User phone number is not in the correct format", "phone_number", cfg.userPhoneNumber)
 			os.Exit(1)
 		}
@@ -82,8 +193,7 @@ func main() {
 	if cfg.twilioPhoneNumber == "" {
 		cfg.twilioPhoneNumber = os.Getenv("TWILIO_PHONE_NUMBER")
 		// check if phone number is the correct format '+19875551234' using regex
-		re := regexp.MustCompile(`^\+[1-9]\d{10,14}$`)
-		if !re.MatchString(cfg.twilioPhoneNumber) {
+		if !phoneNumberRX.MatchString(cfg.twilioPhoneNumber) {
 			logger.Error("Twilio phone number is not in the correct format", "phone_number", cfg.twilioPhoneNumber)
 			os.Exit(1)
 		}
@@ -93,7 +203,18 @@ func main() {
 		}
 	}
 
-	for _, envVar := range []string{"TWILIO_ACCOUNT_SID", "TWILIO_AUTH_TOKEN", "OPENAI_API_KEY"} {
+	// If the webhookURL flag is not set, check the environment. When it is still empty the
+	// URL is rebuilt from the incoming request, which only works without a reverse proxy.
+	if cfg.webhookURL == "" {
+		cfg.webhookURL = os.Getenv("TWILIO_WEBHOOK_URL")
+	}
+
+	// Self-hosted OpenAI-compatible servers usually don't need an API key
+	requiredEnvVars := []string{"TWILIO_ACCOUNT_SID", "TWILIO_AUTH_TOKEN"}
+	if cfg.openai.baseURL == "" && cfg.localIntents != localIntentsPrimary {
+		requiredEnvVars = append(requiredEnvVars, "OPENAI_API_KEY")
+	}
+	for _, envVar := range requiredEnvVars {
 		if os.Getenv(envVar) == "" {
 			logger.Error(fmt.Sprintf("Environment variable %s is not set", envVar))
 			os.Exit(1)
@@ -111,18 +232,93 @@ func main() {
 	}
 
 	// Initialize openai client
-	openaiKey := os.Getenv("OPENAI_API_KEY")
-	openaiClient := goopenai.NewClient(openaiKey)
-	openaiService := service.OpenaiService{Client: openaiClient}
+	openaiService := newOpenaiService(cfg, os.Getenv("OPENAI_API_KEY"))
+	intents, err := newIntentParser(cfg.localIntents, openaiService)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Twilio is always available. The chat transports are enabled by their environment
+	// variables.
+	transports := map[string]service.Transport{
+		service.TransportTwilio: &service.TwilioTransport{Client: twilioClient, From: cfg.twilioPhoneNumber},
+	}
+	if url := os.Getenv("MESSAGE_WEBHOOK_URL"); url != "" {
+		webhook := &service.WebhookTransport{URL: url, Secret: os.Getenv("MESSAGE_WEBHOOK_SECRET")}
+		if !webhook.InboundEnabled() {
+			logger.Warn("Inbound webhook messages are disabled until MESSAGE_WEBHOOK_SECRET is set")
+		}
+		transports[service.TransportWebhook] = webhook
+	}
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		// Without the secret every update would be rejected, so the bot stays off
+		secret := os.Getenv("TELEGRAM_WEBHOOK_SECRET")
+		if secret == "" {
+			logger.Error("Telegram is disabled because TELEGRAM_WEBHOOK_SECRET is not set")
+		} else {
+			transports[service.TransportTelegram] = &service.TelegramTransport{Token: token, Secret: secret}
+		}
+	}
+	if secret := os.Getenv("SLACK_SIGNING_SECRET"); secret != "" {
+		transports[service.TransportSlack] = &service.SlackTransport{SigningSecret: secret, BotToken: os.Getenv("SLACK_BOT_TOKEN")}
+	}
 
 	// Application struct
 	app := &application{
-		config:      cfg,
-		logger:      logger,
-		twilio:      twilioClient,
-		openai:      &openaiService,
-		responseMap: make(map[string]chan JSONMessage),
+		config:        cfg,
+		logger:        logger,
+		transports:    transports,
+		twilioSig:     twilioValidator.NewRequestValidator(twilioPassword),
+		intents:       intents,
+		conversations: service.NewConversations(cfg.conversation.window, cfg.conversation.expiry),
+		questions:     service.NewPending[pendingQuestion](cfg.conversation.questionExpiry),
+		confirmations: service.NewPending[pendingConfirmation](cfg.confirm.expiry),
+		clients:       newClientRegistry(),
+		events:        newEventBroker(),
+	}
+
+	// Open the store and start warm with the last state every client reported
+	store, err := service.NewFileStore(cfg.dataDir)
+	if err != nil {
+		logger.Error("Error opening store", "error", err)
+		os.Exit(1)
+	}
+	app.store = store
+
+	states, err := store.ClientStates()
+	if err != nil {
+		logger.Error("Error loading client state", "error", err)
+		os.Exit(1)
+	}
+	app.clients.restore(states)
+
+	// Load the users allowed to text the server, always including the configured number
+	users, err := service.NewUsers(cfg.usersFile, service.User{Phone: cfg.userPhoneNumber, Name: "Owner"})
+	if err != nil {
+		logger.Error("Error loading users", "error", err)
+		os.Exit(1)
 	}
+	app.users = users
+
+	// Load pending schedules and start running them as they come due
+	scheduler, err := service.NewScheduler(cfg.schedulesFile, app.runSchedule)
+	if err != nil {
+		logger.Error("Error loading schedules", "error", err)
+		os.Exit(1)
+	}
+	app.scheduler = scheduler
+	stop := make(chan struct{})
+	app.scheduler.Start(stop)
+
+	// Load recurring rules and start running them as they come due
+	rules, err := service.NewRules(cfg.rulesFile, cfg.location, app.runRule)
+	if err != nil {
+		logger.Error("Error loading rules", "error", err)
+		os.Exit(1)
+	}
+	app.rules = rules
+	app.rules.Start(stop)
 
 	svr := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
@@ -133,8 +329,37 @@ func main() {
 		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
 
+	// On SIGINT or SIGTERM stop running schedules and rules and let in-flight
+	// requests finish before the store is closed
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+		logger.Info("Shutting down server", "signal", sig.String())
+
+		close(stop)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdownErr <- svr.Shutdown(ctx)
+	}()
+
 	logger.Info("Starting server", "port", cfg.port)
-	err := svr.ListenAndServe()
-	logger.Error(err.Error())
-	os.Exit(1)
+	err = svr.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err.Error())
+		store.Close()
+		os.Exit(1)
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		logger.Error("Error shutting down server", "error", err)
+	}
+	err = store.Close()
+	if err != nil {
+		logger.Error("Error closing store", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Stopped server")
 }
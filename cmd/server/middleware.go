@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+)
+
+// requireAPIToken only lets requests through that carry the API token in an
+// "Authorization: Bearer <token>" header. The home client's auth token is not
+// accepted, so API callers can't connect to /ws as the client.
+func (app *application) requireAPIToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok || !app.validAPIToken(token) {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireStreamToken is requireAPIToken for event streams. Since the browser
+// EventSource can't set headers, it also accepts the API token in a token query
+// parameter, which is removed before the request is handled so it isn't logged.
+func (app *application) requireStreamToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !query.Has("token") {
+			app.requireAPIToken(next).ServeHTTP(w, r)
+			return
+		}
+
+		token := query.Get("token")
+		query.Del("token")
+		r.URL.RawQuery = query.Encode()
+		if !app.validAPIToken(token) {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAPIToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		apiToken      string
+		authorization string
+		wantStatus    int
+	}{
+		{"API token", "api-secret", "Bearer api-secret", http.StatusOK},
+		{"lowercase scheme", "api-secret", "bearer api-secret", http.StatusOK},
+		{"missing header", "api-secret", "", http.StatusUnauthorized},
+		{"wrong token", "api-secret", "Bearer nope", http.StatusUnauthorized},
+		{"other scheme", "api-secret", "Basic api-secret", http.StatusUnauthorized},
+		{"home client token", "api-secret", "Bearer client-secret", http.StatusUnauthorized},
+		{"API disabled", "", "Bearer ", http.StatusUnauthorized},
+		{"API disabled with client token", "", "Bearer client-secret", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			app.config.auth_token = "client-secret"
+			app.config.apiToken = tt.apiToken
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/groups", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Error("got no WWW-Authenticate header, want Bearer")
+			}
+		})
+	}
+}
@@ -9,10 +9,31 @@ import (
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
+	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
 	router.HandlerFunc(http.MethodGet, "/ws", app.handleWSConnections)
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 	router.HandlerFunc(http.MethodPost, "/text", app.twilioWebHookHandler)
+	router.HandlerFunc(http.MethodPost, "/telegram", app.telegramWebhookHandler)
+	router.HandlerFunc(http.MethodPost, "/slack/commands", app.slackCommandHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/messages", app.webhookMessageHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/groups", app.requireAPIToken(app.listGroupsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/groups/:name", app.requireAPIToken(app.showGroupHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/groups/:name/state", app.requireAPIToken(app.updateGroupStateHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/commands", app.requireAPIToken(app.createCommandHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/events", app.requireStreamToken(app.eventsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/history", app.requireAPIToken(app.historyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/rules", app.requireAPIToken(app.listRulesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/rules", app.requireAPIToken(app.createRuleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/rules/:id/pause", app.requireAPIToken(app.changeRuleHandler("pause_rule")))
+	router.HandlerFunc(http.MethodPost, "/v1/rules/:id/resume", app.requireAPIToken(app.changeRuleHandler("resume_rule")))
+	router.HandlerFunc(http.MethodDelete, "/v1/rules/:id", app.requireAPIToken(app.changeRuleHandler("delete_rule")))
 
 	// Return the httprouter instance.
 	return router
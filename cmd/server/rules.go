@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	"github.com/julienschmidt/httprouter"
+)
+
+// GPTRuleRequest represents a request from GPT to create a recurring rule. Either Cron
+// or Sun is set.
+type GPTRuleRequest struct {
+	Description string        `json:"description"`
+	Cron        string        `json:"cron,omitempty"`
+	Sun         string        `json:"sun,omitempty"`
+	Offset      string        `json:"offset,omitempty"`
+	Days        string        `json:"days,omitempty"`
+	Actions     nestedActions `json:"actions"`
+}
+
+// GPTRuleIDRequest represents a request from GPT that refers to an existing rule.
+type GPTRuleIDRequest struct {
+	ID string `json:"id"`
+}
+
+// newRule checks the actions of a rule request and converts it into a rule.
+func newRule(ruleRequest GPTRuleRequest) (service.Rule, error) {
+	if len(ruleRequest.Actions) == 0 {
+		return service.Rule{}, errors.New("a rule needs at least one action")
+	}
+
+	// Only actions that run immediately can repeat
+	for _, action := range ruleRequest.Actions {
+		if action.Type != "update" && action.Type != "scene" {
+			return service.Rule{}, fmt.Errorf("a rule can't run a %s request", action.Type)
+		}
+	}
+
+	actions, err := json.Marshal(ruleRequest.Actions)
+	if err != nil {
+		return service.Rule{}, err
+	}
+
+	description := strings.TrimSpace(ruleRequest.Description)
+	if description == "" {
+		description = "Rule"
+	}
+
+	return service.Rule{
+		Description: description,
+		Cron:        ruleRequest.Cron,
+		Sun:         ruleRequest.Sun,
+		Offset:      ruleRequest.Offset,
+		Days:        ruleRequest.Days,
+		Action:      actions,
+	}, nil
+}
+
+// executeCreateRule stores a new recurring rule that runs as the user.
+func (app *application) executeCreateRule(user service.User, ruleRequest GPTRuleRequest) (string, error) {
+	rule, err := newRule(ruleRequest)
+	if err != nil {
+		return fmt.Sprintf("I couldn't create that rule: %s.", err), err
+	}
+	rule.Owner = user.Phone
+
+	rule, err = app.rules.Add(rule)
+	if err != nil {
+		return fmt.Sprintf("I couldn't create that rule: %s.", err), err
+	}
+	return fmt.Sprintf("Created rule %s.", rule), nil
+}
+
+// changeRule pauses, resumes or deletes the rule with the given ID.
+func (app *application) changeRule(change, id string) (service.Rule, error) {
+	switch change {
+	case "pause_rule":
+		return app.rules.SetPaused(id, true)
+	case "resume_rule":
+		return app.rules.SetPaused(id, false)
+	case "delete_rule":
+		return app.rules.Delete(id)
+	default:
+		return service.Rule{}, fmt.Errorf("unknown rule change %q", change)
+	}
+}
+
+// executeRuleChange pauses, resumes or deletes a rule the user manages. Rules of
+// other users are reported as missing.
+func (app *application) executeRuleChange(user service.User, change string, ruleRequest GPTRuleIDRequest) (string, error) {
+	managed := func(r service.Rule) bool { return app.manages(user, r.Owner) }
+
+	rule, ok := app.rules.Get(ruleRequest.ID)
+	err := service.ErrRuleNotFound
+	if ok && managed(rule) {
+		rule, err = app.changeRule(change, ruleRequest.ID)
+	}
+	if errors.Is(err, service.ErrRuleNotFound) {
+		return fmt.Sprintf("There is no rule %s.\n%s", ruleRequest.ID, app.rules.ListMessage(managed)), err
+	}
+	if err != nil {
+		return "There was an error saving the rule.", err
+	}
+
+	if change == "delete_rule" {
+		return fmt.Sprintf("Deleted rule %s.", rule), nil
+	}
+	return fmt.Sprintf("Rule %s.", rule), nil
+}
+
+// runRule executes a rule that came due through the same path as a text message, as
+// the user who created it. Only failures are texted to them, since rules run often.
+func (app *application) runRule(rule service.Rule) {
+	app.logger.Info("running rule", "id", rule.ID, "description", rule.Description)
+
+	owner, ok := app.owner(rule.Owner)
+	if !ok {
+		app.logger.Warn("skipping rule of removed user", "id", rule.ID, "owner", rule.Owner)
+		return
+	}
+
+	actions, err := parseActions(string(rule.Action))
+	if err != nil {
+		app.logger.Error("error parsing rule actions", "id", rule.ID, "error", err)
+		app.sendTextMessageTo(owner.Phone, fmt.Sprintf("Rule %q could not run.", rule.Description))
+		return
+	}
+
+	var failures []string
+	for _, action := range actions {
+		reply, err := app.executeAction(owner, action)
+		if err != nil {
+			app.logger.Error("error executing rule action", "id", rule.ID, "error", err)
+			failures = append(failures, strings.TrimSpace(reply))
+		}
+	}
+	if len(failures) > 0 {
+		app.sendTextMessageTo(owner.Phone, fmt.Sprintf("Rule %q failed:\n%s", rule.Description, strings.Join(failures, "\n")))
+	}
+}
+
+// listRulesHandler returns every rule.
+func (app *application) listRulesHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"rules": app.rules.List()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createRuleHandler creates a rule from a JSON body shaped like GPTRuleRequest.
+func (app *application) createRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var input GPTRuleRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rule, err := newRule(input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	rule.Owner = app.config.userPhoneNumber
+
+	actions, err := parseActions(string(rule.Action))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	err = app.validateActions(actions, app.clients.displayGroups(), app.clients.displayScenes())
+	if err != nil {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	rule, err = app.rules.Add(rule)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/rules/%s", rule.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"rule": rule}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// changeRuleHandler returns a handler that applies a pause, resume or delete change to
+// the rule named in the URL.
+func (app *application) changeRuleHandler(change string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+		rule, err := app.changeRule(change, id)
+		if errors.Is(err, service.ErrRuleNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"rule": rule}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// GPTScheduleRequest represents a request from GPT to run actions later. Exactly one
+// of At or In is set.
+type GPTScheduleRequest struct {
+	At          string        `json:"at,omitempty"` // RFC 3339 time
+	In          string        `json:"in,omitempty"` // Go duration such as "20m"
+	Description string        `json:"description"`
+	Actions     nestedActions `json:"actions"`
+}
+
+// GPTCancelScheduleRequest represents a request from GPT to cancel a pending schedule.
+type GPTCancelScheduleRequest struct {
+	ID string `json:"id"`
+}
+
+// runAt returns the time the request should run, relative to now.
+func (req GPTScheduleRequest) runAt(now time.Time) (time.Time, error) {
+	switch {
+	case req.In != "":
+		d, err := time.ParseDuration(req.In)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid delay %q: %w", req.In, err)
+		}
+		return now.Add(d), nil
+	case req.At != "":
+		t, err := time.Parse(time.RFC3339, req.At)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: %w", req.At, err)
+		}
+		return t, nil
+	default:
+		return time.Time{}, errors.New("schedule has neither a time nor a delay")
+	}
+}
+
+// executeSchedule stores the actions of a schedule request to run later as the user.
+func (app *application) executeSchedule(user service.User, scheduleRequest GPTScheduleRequest) (string, error) {
+	runAt, err := scheduleRequest.runAt(time.Now())
+	if err != nil {
+		return "I couldn't understand when to do that.", err
+	}
+	if !runAt.After(time.Now()) {
+		return "That time has already passed.", fmt.Errorf("schedule time %s is in the past", runAt)
+	}
+
+	if len(scheduleRequest.Actions) == 0 {
+		return "I couldn't tell what to schedule.", errors.New("schedule has no actions")
+	}
+
+	// Only actions that run immediately can be scheduled
+	for _, action := range scheduleRequest.Actions {
+		if action.Type != "update" && action.Type != "scene" {
+			return fmt.Sprintf("I can't schedule a %s request.", action.Type),
+				fmt.Errorf("cannot schedule action type %q", action.Type)
+		}
+	}
+
+	actions, err := json.Marshal(scheduleRequest.Actions)
+	if err != nil {
+		return "There was an error processing your request.", err
+	}
+
+	description := strings.TrimSpace(scheduleRequest.Description)
+	if description == "" {
+		description = "Scheduled action"
+	}
+
+	sc, err := app.scheduler.Add(runAt, description, user.Phone, actions)
+	if err != nil {
+		return "There was an error saving the schedule.", err
+	}
+	return fmt.Sprintf("Scheduled %s. Reply \"cancel %s\" to cancel it.", sc, sc.ID), nil
+}
+
+// executeCancelSchedule cancels a pending schedule the user manages. Schedules of
+// other users are reported as missing.
+func (app *application) executeCancelSchedule(user service.User, cancelRequest GPTCancelScheduleRequest) (string, error) {
+	managed := func(sc service.Schedule) bool { return app.manages(user, sc.Owner) }
+
+	sc, ok := app.scheduler.Get(cancelRequest.ID)
+	err := service.ErrScheduleNotFound
+	if ok && managed(sc) {
+		sc, err = app.scheduler.Cancel(cancelRequest.ID)
+	}
+	if errors.Is(err, service.ErrScheduleNotFound) {
+		return fmt.Sprintf("There is no schedule %s.\n%s", cancelRequest.ID, app.scheduler.ListMessage(managed)), err
+	}
+	if err != nil {
+		return "There was an error cancelling the schedule.", err
+	}
+	return fmt.Sprintf("Cancelled %s.", sc), nil
+}
+
+// runSchedule executes a schedule that came due through the same path as a text
+// message, as the user who created it, and texts them the outcome.
+func (app *application) runSchedule(sc service.Schedule) {
+	app.logger.Info("running schedule", "id", sc.ID, "description", sc.Description)
+
+	owner, ok := app.owner(sc.Owner)
+	if !ok {
+		app.logger.Warn("skipping schedule of removed user", "id", sc.ID, "owner", sc.Owner)
+		return
+	}
+
+	actions, err := parseActions(string(sc.Action))
+	if err != nil {
+		app.logger.Error("error parsing scheduled actions", "id", sc.ID, "error", err)
+		app.sendTextMessageTo(owner.Phone, fmt.Sprintf("Scheduled %q could not run.", sc.Description))
+		return
+	}
+
+	reply := app.executeActions(owner, actions)
+	app.sendTextMessageTo(owner.Phone, fmt.Sprintf("%s:\n%s", sc.Description, reply))
+}
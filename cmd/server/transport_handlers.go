@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// inboundUser records a message received through a transport and returns the user it
+// came from. Messages from unknown senders are logged and ignored.
+func (app *application) inboundUser(transport, id, text string) (service.User, bool) {
+	from := service.Address(transport, id)
+	app.recordHistory(service.HistoryInboundMessage, from, "", text, nil)
+
+	user, ok := app.users.Lookup(from)
+	if !ok {
+		app.logger.Error("received a message from an unauthorized sender", "transport", transport, "from", from)
+	}
+	return user, ok
+}
+
+// replyAsync runs the text through the intent pipeline in the background and sends
+// the reply with send. Chat platforms expect their webhooks to be acknowledged
+// quickly, long before OpenAI and the home client have answered.
+func (app *application) replyAsync(user service.User, text string, send func(reply string) error) {
+	go func() {
+		reply, err := app.processText(user, text)
+		if err != nil {
+			app.logger.Error(err.Error(), "from", user.Phone)
+		}
+
+		err = send(reply)
+		if err != nil {
+			app.logger.Error("error sending reply", "to", user.Phone, "error", err)
+		}
+	}()
+}
+
+// webhookMessageHandler accepts messages from the generic webhook transport and
+// responds with the reply. Senders authenticate with the webhook's own secret rather
+// than the home client token.
+func (app *application) webhookMessageHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.transports[service.TransportWebhook].(*service.WebhookTransport)
+	if !ok || !webhook.InboundEnabled() {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if !webhook.ValidSecret(r) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	var input service.WebhookMessage
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.From == "" || strings.TrimSpace(input.Text) == "" {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "from and text must be provided")
+		return
+	}
+
+	user, ok := app.inboundUser(service.TransportWebhook, input.From, input.Text)
+	if !ok {
+		app.errorResponse(w, r, http.StatusForbidden, "the sender is not an authorized user")
+		return
+	}
+
+	reply, err := app.processText(user, input.Text)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reply": reply}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// telegramWebhookHandler handles the updates Telegram posts for the bot.
+func (app *application) telegramWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	telegram, ok := app.transports[service.TransportTelegram].(*service.TelegramTransport)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	chatID, text, ok, err := telegram.ParseUpdate(r, body)
+	if err != nil {
+		app.logger.Warn("rejected Telegram update", "remote_addr", r.RemoteAddr, "error", err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if ok {
+		if user, ok := app.inboundUser(service.TransportTelegram, chatID, text); ok {
+			app.replyAsync(user, text, func(reply string) error {
+				return telegram.Notify(chatID, reply)
+			})
+		}
+	}
+
+	// Telegram retries updates until it gets a 200 OK
+	w.WriteHeader(http.StatusOK)
+}
+
+// slackCommandHandler handles the slash commands Slack posts for the app.
+func (app *application) slackCommandHandler(w http.ResponseWriter, r *http.Request) {
+	slack, ok := app.transports[service.TransportSlack].(*service.SlackTransport)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	cmd, err := slack.ParseCommand(r, body)
+	if err != nil {
+		app.logger.Warn("rejected Slack command", "remote_addr", r.RemoteAddr, "error", err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if user, ok := app.inboundUser(service.TransportSlack, cmd.UserID, cmd.Text); ok {
+		app.replyAsync(user, cmd.Text, func(reply string) error {
+			return slack.Reply(cmd, reply)
+		})
+	}
+
+	// Slack needs an acknowledgement within 3 seconds. The reply follows through the
+	// command's response URL.
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+func TestWebhookMessageHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		secret        string // configured
+		authorization string
+		body          string
+		wantStatus    int
+		wantReply     string
+	}{
+		{
+			name:          "disabled without a secret",
+			authorization: "Bearer ",
+			body:          `{"from":"alice","text":"reset"}`,
+			wantStatus:    http.StatusNotFound,
+		},
+		{
+			name:          "wrong secret",
+			secret:        "hook-secret",
+			authorization: "Bearer client-secret",
+			body:          `{"from":"alice","text":"reset"}`,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:       "missing secret",
+			secret:     "hook-secret",
+			body:       `{"from":"alice","text":"reset"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "unknown sender",
+			secret:        "hook-secret",
+			authorization: "Bearer hook-secret",
+			body:          `{"from":"mallory","text":"reset"}`,
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "missing text",
+			secret:        "hook-secret",
+			authorization: "Bearer hook-secret",
+			body:          `{"from":"alice"}`,
+			wantStatus:    http.StatusUnprocessableEntity,
+		},
+		{
+			name:          "reply",
+			secret:        "hook-secret",
+			authorization: "Bearer hook-secret",
+			body:          `{"from":"alice","text":"reset"}`,
+			wantStatus:    http.StatusOK,
+			wantReply:     "Okay, I've forgotten our conversation.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			app.config.auth_token = "client-secret"
+			app.transports[service.TransportWebhook] = &service.WebhookTransport{URL: "http://example.com/hook", Secret: tt.secret}
+			if err := app.users.Add(service.User{Phone: "webhook:alice", Name: "Alice"}); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantReply != "" {
+				var res struct{ Reply string }
+				if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+					t.Fatal(err)
+				}
+				if res.Reply != tt.wantReply {
+					t.Errorf("got reply %q, want %q", res.Reply, tt.wantReply)
+				}
+			}
+		})
+	}
+}
+
+func TestTelegramWebhookHandler(t *testing.T) {
+	update := `{"update_id":1,"message":{"text":"reset","from":{"id":42},"chat":{"id":42,"type":"private"}}}`
+
+	tests := []struct {
+		name         string
+		secret       string // sent
+		wantStatus   int
+		wantRecorded bool
+	}{
+		{"valid secret", "s3cret", http.StatusOK, true},
+		{"wrong secret", "guess", http.StatusForbidden, false},
+		{"missing secret", "", http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			app.transports[service.TransportTelegram] = &service.TelegramTransport{Token: "bot-token", Secret: "s3cret"}
+
+			r := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(update))
+			if tt.secret != "" {
+				r.Header.Set("X-Telegram-Bot-Api-Secret-Token", tt.secret)
+			}
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			history, err := app.store.History(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recorded := len(history) > 0; recorded != tt.wantRecorded {
+				t.Errorf("got %d history entries, want the message recorded only when accepted", len(history))
+			}
+		})
+	}
+}
+
+func TestTelegramWebhookHandlerDisabled(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{}`))
+	r.Header.Set("X-Telegram-Bot-Api-Secret-Token", "")
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSlackCommandHandler(t *testing.T) {
+	body := url.Values{"user_id": {"U024BE7LH"}, "text": {"reset"}, "response_url": {"http://127.0.0.1:1/unused"}}.Encode()
+	sign := func(secret string) (timestamp, signature string) {
+		timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+		return timestamp, "v0=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name       string
+		secret     string // signed with
+		wantStatus int
+	}{
+		{"valid signature", "signing-secret", http.StatusOK},
+		{"wrong signature", "other-secret", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			app.transports[service.TransportSlack] = &service.SlackTransport{SigningSecret: "signing-secret"}
+
+			timestamp, signature := sign(tt.secret)
+			r := httptest.NewRequest(http.MethodPost, "/slack/commands", strings.NewReader(body))
+			r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+			r.Header.Set("X-Slack-Signature", signature)
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
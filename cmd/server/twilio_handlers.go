@@ -1,40 +1,110 @@
 package main
 
 import (
-	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/TimEngleSF/remote-hue-server/internal/service"
-	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
 )
 
 // GPTStatusRequest represents the structure of the status request from GPT.
 type GPTStatusRequest struct {
-	Data struct {
-		Rooms service.GroupNames `json:"room"`
-	} `json:"data"`
+	Data GPTStatusData `json:"data"`
+}
+
+// GPTStatusData holds the groups a status request asks about.
+type GPTStatusData struct {
+	Rooms service.GroupNames `json:"room"`
 }
 
 type GPTUpdateRequest struct {
-	Group      string `json:"group"`
-	IsOn       bool   `json:"isOn"`
-	Brightness *int   `json:"brightness,omitempty"` // HOW TO OMIT IF NOT SET
+	Group           string  `json:"group"`
+	IsOn            bool    `json:"isOn"`
+	Brightness      *int    `json:"brightness,omitempty"`      // HOW TO OMIT IF NOT SET
+	BrightnessDelta *int    `json:"brightnessDelta,omitempty"` // percent of full brightness, relative to current
+	ColorTemp       *int    `json:"colorTemp,omitempty"`       // kelvin
+	Color           *string `json:"color,omitempty"`           // one of service.ColorNames
+	Effect          *string `json:"effect,omitempty"`          // one of service.Effects
+}
+
+// GPTSceneRequest represents the structure of a scene request from GPT.
+type GPTSceneRequest struct {
+	Group string `json:"group"`
+	Scene string `json:"scene"`
+}
+
+// ClientSceneData represents the data of a recall_scene message sent to the client.
+type ClientSceneData struct {
+	Group   string `json:"group"`
+	SceneID string `json:"sceneId"`
+	Scene   string `json:"scene"`
 }
 
 // ClientUpdateData represents the data to be sent to the client.
 type ClientUpdateData struct {
-	Group      string `json:"group"`
-	IsOn       bool   `json:"isOn"`
-	Brightness *int   `json:"brightness,omitempty"`
+	Group      string    `json:"group"`
+	IsOn       bool      `json:"isOn"`
+	Brightness *int      `json:"brightness,omitempty"`
+	CT         *uint16   `json:"ct,omitempty"` // mired
+	XY         []float32 `json:"xy,omitempty"`
+	Hue        *uint16   `json:"hue,omitempty"`
+	Sat        *uint8    `json:"sat,omitempty"`
+	Effect     *string   `json:"effect,omitempty"`
+	All        bool      `json:"all,omitempty"` // apply to every light through the bridge's group 0
+}
+
+// newClientUpdateData converts an update request into the data sent to the client,
+// translating kelvin to mired and color names to the formats the bridge accepts.
+func newClientUpdateData(req GPTUpdateRequest, bridgeName string) (ClientUpdateData, error) {
+	data := ClientUpdateData{
+		Group:      bridgeName,
+		IsOn:       req.IsOn,
+		Brightness: req.Brightness,
+	}
+
+	if req.ColorTemp != nil {
+		ct := service.KelvinToMired(*req.ColorTemp)
+		data.CT = &ct
+	}
+
+	if req.Color != nil {
+		color, ok := service.LookupColor(*req.Color)
+		if !ok {
+			return ClientUpdateData{}, fmt.Errorf("I don't know the color '%s'. Known colors: %s",
+				*req.Color, strings.Join(service.ColorNames(), ", "))
+		}
+		data.XY = color.XY
+		data.Hue = &color.Hue
+		data.Sat = &color.Sat
+	}
+
+	if req.Effect != nil {
+		effect := strings.ToLower(*req.Effect)
+		if !slices.Contains(service.Effects, effect) {
+			return ClientUpdateData{}, fmt.Errorf("I don't know the effect '%s'. Known effects: %s",
+				*req.Effect, strings.Join(service.Effects, ", "))
+		}
+		data.Effect = &effect
+	}
+
+	return data, nil
 }
 
-// ClientUpdateMessage represents the update message to be sent to the client.
-type ClientUpdateMessage struct {
-	Type string           `json:"type"`
-	Data ClientUpdateData `json:"data"`
+// twimlReplyTimeout is how long the webhook waits for a reply it can return as TwiML.
+// It stays below the server's write timeout and Twilio's 15 second webhook timeout.
+// Tests shorten it.
+var twimlReplyTimeout = 8 * time.Second
+
+// TwiMLResponse is the TwiML document the webhook responds with when replying inline.
+type TwiMLResponse struct {
+	XMLName  xml.Name `xml:"Response"`
+	Messages []string `xml:"Message"`
 }
 
 // twilioWebHookHandler handles incoming requests from Twilio's webhook.
@@ -48,6 +118,14 @@ func (app *application) twilioWebHookHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Reject requests that were not signed by Twilio with our auth token
+	if !app.validTwilioSignature(r, body) {
+		app.logger.Warn("rejected webhook request with invalid Twilio signature",
+			"remote_addr", r.RemoteAddr, "uri", r.URL.RequestURI())
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	// Parse incoming data from Twilio
 	formData, err := url.ParseQuery(string(body))
 	if err != nil {
@@ -58,137 +136,163 @@ func (app *application) twilioWebHookHandler(w http.ResponseWriter, r *http.Requ
 	from := formData.Get("From")
 	bodyText := formData.Get("Body")
 
-	if from != app.config.userPhoneNumber {
-		app.logger.Error("received a text message from an unauthorized number", "unauthorized_number", from)
-		w.WriteHeader(http.StatusOK)
+	user, ok := app.inboundUser(service.TransportTwilio, from, bodyText)
+	if !ok {
+		app.writeTwiML(w)
 		return
 	}
 
-	systemRoleMessage := service.SystemRoleMessage(*app.groupsState, app.groupNames)
-	var jsonMessage JSONMessage
+	if !app.config.twimlReplies {
+		// Run the text through the intent pipeline and reply to the sender with the outcome
+		reply, err := app.processText(user, bodyText)
+		if err != nil {
+			app.logError(r, err)
+		}
+		app.sendTextMessageTo(from, reply)
 
-	// Call the OpenAI API
-	gptResponse, err := app.openai.TranformTextBodyToJSON(systemRoleMessage, bodyText)
-	if err != nil {
-		app.sendErrorTextMessage("There was an error communicating with openai. \n Please try again.")
-		app.logError(r, err)
-		return
-	}
-
-	err = jsonMessage.UnmarshalJSON([]byte(gptResponse))
-	if err != nil {
-		app.sendErrorTextMessage("There was an error parsing json")
-		app.logError(r, err)
+		// Respond with a status 200 OK
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	// Process request based on type
-	switch jsonMessage.Type {
-	case "status":
-		app.handleStatusRequest(jsonMessage)
-	case "update":
-		fmt.Println("Update!")
-		fmt.Printf("%+v", jsonMessage)
-		app.handleUpdateRequest(jsonMessage)
-	default:
-		app.logger.Error("received a text message with an unknown type", "type", jsonMessage.Type)
-	}
+	// Reply inline with TwiML when the pipeline finishes in time. Otherwise answer Twilio
+	// with an empty response and text the reply through the REST API once it is ready.
+	replies := make(chan string)
+	late := make(chan struct{})
+	go func() {
+		reply, err := app.processText(user, bodyText)
+		if err != nil {
+			app.logger.Error(err.Error(), "from", from)
+		}
 
-	// Respond with a status 200 OK
-	w.WriteHeader(http.StatusOK)
-}
+		select {
+		case replies <- reply:
+		case <-late:
+			app.sendTextMessageTo(from, reply)
+		}
+	}()
 
-// Processes the status request from the JSON message.
-func (app *application) handleStatusRequest(jsonMsg JSONMessage) {
-	// Update group state field
-	err := app.SetGroupsStateField()
-	if err != nil {
-		app.logger.Error("error getting groups state", "error", err)
-		app.sendErrorTextMessage("There was an error getting the groups state. \n Please try again.")
-		return
+	timer := time.NewTimer(twimlReplyTimeout)
+	defer timer.Stop()
+	select {
+	case reply := <-replies:
+		app.writeTwiML(w, reply)
+	case <-timer.C:
+		close(late)
+		// The reply may have arrived just as the timer fired
+		select {
+		case reply := <-replies:
+			app.writeTwiML(w, reply)
+		default:
+			app.logger.Info("reply not ready in time, sending it through the REST API", "from", from)
+			app.writeTwiML(w)
+		}
 	}
+}
 
-	// Unmarshal JSON message data into GPTStatusRequest struct
-	var statusRequest GPTStatusRequest
-	data, err := json.Marshal(jsonMsg.Data)
-	if err != nil {
-		app.logger.Error("error marshalling json message")
-		app.sendErrorTextMessage("There was an error processing your request. \n Please try again.")
-		return
+// writeTwiML responds to Twilio with a TwiML document that texts each message back to
+// the sender. Empty messages are dropped, so without any nothing is sent.
+func (app *application) writeTwiML(w http.ResponseWriter, messages ...string) {
+	var res TwiMLResponse
+	for _, msg := range messages {
+		if strings.TrimSpace(msg) != "" {
+			res.Messages = append(res.Messages, msg)
+		}
 	}
 
-	err = json.Unmarshal(data, &statusRequest.Data)
+	out, err := xml.Marshal(res)
 	if err != nil {
-		app.logger.Error("error unmarshalling json message")
-		app.sendErrorTextMessage("There was an error processing your request. \n Please try again.")
+		app.logger.Error("error encoding TwiML", "error", err)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	// Prepare and send the Twilio message
-	params := &twilioApi.CreateMessageParams{}
-	params.SetTo(app.config.userPhoneNumber)
-	params.SetFrom(app.config.twilioPhoneNumber)
-	params.SetBody(app.groupsState.GroupStatusMessage(service.GroupNames(statusRequest.Data.Rooms)))
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
 
-	_, err = app.twilio.Api.CreateMessage(params)
-	if err != nil {
-		app.logger.Error("error sending Twilio message", "error", err)
+// validTwilioSignature reports whether the X-Twilio-Signature header matches the
+// HMAC Twilio computes over the public webhook URL and the posted form body.
+func (app *application) validTwilioSignature(r *http.Request, body []byte) bool {
+	signature := r.Header.Get("X-Twilio-Signature")
+	if signature == "" {
+		return false
 	}
+
+	return app.twilioSig.ValidateBody(app.twilioWebhookURL(r), body, signature)
 }
 
-// Handles request that update the state of groups.
-func (app *application) handleUpdateRequest(jsonMsg JSONMessage) {
-	var updateRequest GPTUpdateRequest
-	// Marshal JSONMessage Data to JSON
-	data, err := json.Marshal(jsonMsg.Data)
-	if err != nil {
-		app.logger.Error("error marshalling json message")
-		return
+// twilioWebhookURL returns the URL Twilio used to reach the webhook. The configured
+// webhookURL wins, since behind a reverse proxy the request no longer carries it.
+func (app *application) twilioWebhookURL(r *http.Request) string {
+	if app.config.webhookURL != "" {
+		return app.config.webhookURL
 	}
 
-	// Unmarshal JSON Data into GPTUpdateRequest
-	err = json.Unmarshal(data, &updateRequest)
-	if err != nil {
-		app.logger.Error("error unmarshalling JSON to GPTUpdateRequest", "error", err)
-		return
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
 	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI())
+}
 
-	if updateRequest.Brightness != nil {
-		fmt.Printf("Brightness is set to: %d\n", *updateRequest.Brightness)
-	} else {
-		fmt.Println("Brightness is not set")
+// UpdateResult represents the data of the update_result message a client replies
+// with after applying an update.
+type UpdateResult struct {
+	Success bool           `json:"success"`
+	Errors  []LightError   `json:"errors,omitempty"`
+	Group   service.Group  `json:"group"`
+	Groups  service.Groups `json:"groups,omitempty"` // resulting state of every group after an "all" update
+}
+
+// LightError describes why a single light in a group could not be updated.
+type LightError struct {
+	Light string `json:"light"`
+	Error string `json:"error"`
+}
+
+// FailureReason summarizes the errors the client reported.
+func (ur UpdateResult) FailureReason() string {
+	if len(ur.Errors) == 0 {
+		return "The bridge did not say why."
 	}
 
-	// Log the received update request for debugging
-	fmt.Printf("Received Update Request: %+v\n", updateRequest)
-	if updateRequest.Brightness != nil {
-		fmt.Printf("Brightness is set to: %d\n", *updateRequest.Brightness)
-	} else {
-		fmt.Println("Brightness is not set")
+	reasons := make([]string, 0, len(ur.Errors))
+	for _, le := range ur.Errors {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", le.Light, le.Error))
 	}
+	return strings.Join(reasons, "; ")
+}
+
+// sendErrorTextMessage texts an error message to the user.
+func (app *application) sendErrorTextMessage(msg string) {
+	app.sendTextMessage(msg)
+}
 
-	clientUpdateData := ClientUpdateData(updateRequest)
+// sendTextMessage texts msg to the configured owner.
+func (app *application) sendTextMessage(msg string) {
+	app.sendTextMessageTo(app.config.userPhoneNumber, msg)
+}
 
-	// Prepare Client Update Message
-	clientUpdateMessage := ClientUpdateMessage{
-		Type: "update",
-		Data: clientUpdateData,
+// sendTextMessageTo sends msg to the user address through its transport: a text for a
+// phone number, or a chat message for addresses such as "telegram:123456789". Empty
+// messages are not sent.
+func (app *application) sendTextMessageTo(to, msg string) {
+	if strings.TrimSpace(msg) == "" {
+		return
 	}
 
-	// Send Update Message to Client via WebSocket
-	err = app.wsConnection.WriteJSON(clientUpdateMessage)
-	if err != nil {
-		app.logger.Error("error sending client update message", "error", err)
+	name, id := service.SplitAddress(to)
+	transport, ok := app.transports[name]
+	if !ok {
+		app.logger.Error("no transport configured for address", "to", to)
+		return
 	}
-}
 
-func (app *application) sendErrorTextMessage(msg string) {
-	params := &twilioApi.CreateMessageParams{}
-	params.SetTo(app.config.userPhoneNumber)
-	params.SetFrom(app.config.twilioPhoneNumber)
-	params.SetBody(msg)
-	_, err := app.twilio.Api.CreateMessage(params)
+	err := transport.Notify(id, msg)
 	if err != nil {
-		app.logger.Error("error sending Twilio message", "error", err)
+		app.logger.Error("error sending message", "transport", name, "to", to, "error", err)
 	}
 }
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	twilioValidator "github.com/twilio/twilio-go/client"
+)
+
+const testTwilioToken = "test-auth-token"
+
+// twilioSignature computes the X-Twilio-Signature Twilio sends for a form posted to
+// webhookURL: the base64 HMAC-SHA1 of the URL followed by each sorted key and value.
+func twilioSignature(token, webhookURL string, form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := webhookURL
+	for _, k := range keys {
+		s += k + form.Get(k)
+	}
+
+	mac := hmac.New(sha1.New, []byte(token))
+	mac.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newTwilioTestApp returns an application that checks signatures with the test token
+// and knows no users besides the owner, so signed texts stop after being recorded.
+func newTwilioTestApp(t *testing.T, webhookURL string) *application {
+	t.Helper()
+
+	dir := t.TempDir()
+	store, err := service.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := service.NewUsers(filepath.Join(dir, "users.json"), service.User{Phone: "+15550001111", Name: "Owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		twilioSig: twilioValidator.NewRequestValidator(testTwilioToken),
+		store:     store,
+		users:     users,
+	}
+	app.config.webhookURL = webhookURL
+	return app
+}
+
+func TestTwilioWebHookHandlerSignature(t *testing.T) {
+	form := url.Values{
+		"From":       {"+15559998888"},
+		"Body":       {"kitchen off"},
+		"MessageSid": {"SM123"},
+	}
+
+	tests := []struct {
+		name       string
+		webhookURL string // configured public URL
+		requestURL string
+		signedURL  string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "valid signature",
+			requestURL: "http://hue.example.com/text",
+			signedURL:  "http://hue.example.com/text",
+			body:       form.Encode(),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "tampered body",
+			requestURL: "http://hue.example.com/text",
+			signedURL:  "http://hue.example.com/text",
+			body:       strings.Replace(form.Encode(), "kitchen", "bedroom", 1),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "wrong URL",
+			requestURL: "http://hue.example.com/text",
+			signedURL:  "http://attacker.example.com/text",
+			body:       form.Encode(),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "TLS request",
+			requestURL: "https://hue.example.com/text",
+			signedURL:  "https://hue.example.com/text",
+			body:       form.Encode(),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "configured URL behind a proxy",
+			webhookURL: "https://hue.example.com/text",
+			requestURL: "http://localhost:4000/text",
+			signedURL:  "https://hue.example.com/text",
+			body:       form.Encode(),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "request URL when another is configured",
+			webhookURL: "https://hue.example.com/text",
+			requestURL: "http://localhost:4000/text",
+			signedURL:  "http://localhost:4000/text",
+			body:       form.Encode(),
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTwilioTestApp(t, tt.webhookURL)
+
+			r := httptest.NewRequest(http.MethodPost, tt.requestURL, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("X-Twilio-Signature", twilioSignature(testTwilioToken, tt.signedURL, form))
+			w := httptest.NewRecorder()
+
+			app.twilioWebHookHandler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			// Only signed texts get as far as the history
+			history, err := app.store.History(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if accepted := len(history) > 0; accepted != (tt.wantStatus != http.StatusForbidden) {
+				t.Errorf("got %d history entries, want the text recorded only when accepted", len(history))
+			}
+		})
+	}
+}
+
+func TestTwilioWebHookHandlerMissingSignature(t *testing.T) {
+	app := newTwilioTestApp(t, "")
+
+	form := url.Values{"From": {"+15559998888"}, "Body": {"kitchen off"}}
+	r := httptest.NewRequest(http.MethodPost, "http://hue.example.com/text", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	app.twilioWebHookHandler(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// slowParser answers every request with its intents and error after a delay.
+type slowParser struct {
+	delay   time.Duration
+	intents []service.Intent
+	err     error
+}
+
+func (p slowParser) ParseIntent(service.IntentRequest) ([]service.Intent, error) {
+	time.Sleep(p.delay)
+	return p.intents, p.err
+}
+
+func TestTwilioWebHookHandlerReplies(t *testing.T) {
+	const (
+		sender        = "+15559998888"
+		webhookURL    = "https://hue.example.com/text"
+		notUnderstood = "Sorry, I didn't understand that. Try something like \"kitchen off\"."
+	)
+
+	defer func(timeout time.Duration) { twimlReplyTimeout = timeout }(twimlReplyTimeout)
+	twimlReplyTimeout = 50 * time.Millisecond
+
+	tests := []struct {
+		name        string
+		twiml       bool
+		parser      slowParser
+		wantMessage string // inline in the TwiML response
+		wantSent    string // through the REST API
+	}{
+		{
+			name:        "inline reply",
+			twiml:       true,
+			parser:      slowParser{err: service.ErrNoIntent},
+			wantMessage: notUnderstood,
+		},
+		{
+			name:     "late reply",
+			twiml:    true,
+			parser:   slowParser{delay: 200 * time.Millisecond, err: service.ErrNoIntent},
+			wantSent: notUnderstood,
+		},
+		{
+			name:   "empty reply",
+			twiml:  true,
+			parser: slowParser{},
+		},
+		{
+			name:     "TwiML replies disabled",
+			parser:   slowParser{err: service.ErrNoIntent},
+			wantSent: notUnderstood,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake := newTestApp(t)
+			app.twilioSig = twilioValidator.NewRequestValidator(testTwilioToken)
+			app.config.webhookURL = webhookURL
+			app.config.twimlReplies = tt.twiml
+			app.transports[service.TransportTwilio] = fake
+			app.intents = tt.parser
+			app.clients.add(newTestClient("home", "Kitchen"))
+			if err := app.users.Add(service.User{Phone: sender, Name: "Alice"}); err != nil {
+				t.Fatal(err)
+			}
+
+			form := url.Values{"From": {sender}, "Body": {"make it cozy"}}
+			r := httptest.NewRequest(http.MethodPost, webhookURL, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("X-Twilio-Signature", twilioSignature(testTwilioToken, webhookURL, form))
+			w := httptest.NewRecorder()
+
+			app.twilioWebHookHandler(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+			}
+
+			if tt.twiml {
+				var res TwiMLResponse
+				if err := xml.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					t.Fatalf("reading the TwiML response %q: %v", w.Body, err)
+				}
+				var want []string
+				if tt.wantMessage != "" {
+					want = []string{tt.wantMessage}
+				}
+				if !reflect.DeepEqual(res.Messages, want) {
+					t.Errorf("got TwiML messages %q, want %q", res.Messages, want)
+				}
+			}
+
+			// A late reply is sent once the pipeline finishes
+			var want []service.SentMessage
+			if tt.wantSent != "" {
+				want = []service.SentMessage{{To: sender, Text: tt.wantSent}}
+			}
+			deadline := time.Now().Add(time.Second)
+			for len(fake.Sent()) < len(want) && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if sent := fake.Sent(); !reflect.DeepEqual(sent, want) {
+				t.Errorf("got sent messages %v, want %v", sent, want)
+			}
+		})
+	}
+}
+
+func TestWriteTwiML(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	tests := []struct {
+		name     string
+		messages []string
+		want     string
+	}{
+		{"no messages", nil, "<Response></Response>"},
+		{"empty message", []string{" \n"}, "<Response></Response>"},
+		{"message", []string{"Kitchen: Off"}, "<Response><Message>Kitchen: Off</Message></Response>"},
+		{"escaped", []string{"Kitchen & Porch"}, "<Response><Message>Kitchen &amp; Porch</Message></Response>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.writeTwiML(w, tt.messages...)
+
+			if got := strings.TrimPrefix(w.Body.String(), xml.Header); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/xml" {
+				t.Errorf("got content type %q, want text/xml", ct)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// phoneNumberRX matches phone numbers in E.164 format, e.g. '+19875551234'.
+var phoneNumberRX = regexp.MustCompile(`^\+[1-9]\d{10,14}$`)
+
+// apiUser is the user that requests made through the authenticated REST API run as.
+var apiUser = service.User{Name: "api", Admin: true}
+
+// actionPermission returns the kind of action a user needs permission for to run an
+// action of the given type.
+func actionPermission(actionType string) string {
+	switch actionType {
+	case "schedule", "list_schedules", "cancel_schedule":
+		return "schedule"
+	case "create_rule", "list_rules", "pause_rule", "resume_rule", "delete_rule":
+		return "rules"
+	default:
+		return actionType
+	}
+}
+
+// authorizeAction checks that the user may run the action and control every group it
+// touches, including the actions nested in schedules and rules. The error is written
+// to be texted back to the user.
+func (app *application) authorizeAction(user service.User, action service.Intent) error {
+	if !user.Can(actionPermission(action.Type)) {
+		return fmt.Errorf("You aren't allowed to run %s requests.", actionPermission(action.Type))
+	}
+
+	var groups []string
+	var nested []service.Intent
+	switch data := action.Data.(type) {
+	case GPTStatusData:
+		groups = data.Rooms
+	case GPTUpdateRequest:
+		groups = []string{data.Group}
+	case GPTSceneRequest:
+		groups = []string{data.Group}
+	case GPTScheduleRequest, GPTRuleRequest:
+		nested, _ = nestedActionsOf(action)
+	}
+
+	for _, group := range groups {
+		if strings.EqualFold(group, allGroupsTarget) && !user.CanControlAll() {
+			return errors.New("You aren't allowed to control every group.")
+		}
+		if !app.canControl(user, group) {
+			return fmt.Errorf("You aren't allowed to control %s.", group)
+		}
+	}
+
+	for _, nestedAction := range nested {
+		if err := app.authorizeAction(user, nestedAction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validUserAddress reports whether addr is a phone number or the address of a user
+// on one of the configured chat transports.
+func (app *application) validUserAddress(addr string) bool {
+	transport, id := service.SplitAddress(addr)
+	if transport == service.TransportTwilio {
+		return phoneNumberRX.MatchString(id)
+	}
+	_, ok := app.transports[transport]
+	return ok && id != ""
+}
+
+// owner returns the user that schedules and rules created by phone run as. Those
+// created before users were tracked belong to the configured owner.
+func (app *application) owner(phone string) (service.User, bool) {
+	if phone == "" {
+		phone = app.config.userPhoneNumber
+	}
+	return app.users.Lookup(phone)
+}
+
+// manages reports whether the user may see and change the schedules and rules
+// created by owner: their own, or everyone's for admins.
+func (app *application) manages(user service.User, owner string) bool {
+	if owner == "" {
+		owner = app.config.userPhoneNumber
+	}
+	return user.Admin || user.Phone == owner
+}
+
+// canControl reports whether the user may control the group with the given display
+// name. Permissions follow the group to the client that owns it, so they still apply
+// once its name has been qualified with the client ID.
+func (app *application) canControl(user service.User, name string) bool {
+	if cg, ok := app.clients.lookupGroup(name); ok {
+		return user.CanControl(cg.client.id, cg.bridgeName)
+	}
+	return user.CanControl("", name)
+}
+
+// userGroups returns the groups the user may control, under their display names.
+func (app *application) userGroups(user service.User) service.Groups {
+	var groups service.Groups
+	for _, cg := range app.clients.groups() {
+		if user.CanControl(cg.client.id, cg.bridgeName) {
+			groups = append(groups, cg.Group)
+		}
+	}
+	return groups
+}
+
+// userScenes returns the scenes of the groups the user may control, named by the
+// display name of their group.
+func (app *application) userScenes(user service.User) service.Scenes {
+	var scenes service.Scenes
+	for _, cg := range app.clients.groups() {
+		if !user.CanControl(cg.client.id, cg.bridgeName) {
+			continue
+		}
+		for _, sc := range cg.scenes() {
+			sc.Group = cg.Name
+			scenes = append(scenes, sc)
+		}
+	}
+	return scenes
+}
+
+// permittedGroups resolves the group names given to "add user" to the client that
+// owns each group, as "clientID/Group", so the permission stays attached to that
+// group when another client reports one with the same name.
+func (app *application) permittedGroups(names []string) ([]string, error) {
+	groups := make([]string, 0, len(names))
+	for _, name := range names {
+		cg, ok := app.clients.lookupGroup(name)
+		if !ok {
+			return nil, fmt.Errorf("I don't know a group called '%s'. Known groups: %s",
+				name, strings.Join(app.clients.displayGroups().Names(), ", "))
+		}
+		groups = append(groups, fmt.Sprintf("%s/%s", cg.client.id, cg.bridgeName))
+	}
+	return groups, nil
+}
+
+// adminCommand runs the user management commands admins can text:
+//
+//	users
+//	add user <phone> <name> [admin] [groups=Kitchen,Bedroom] [actions=status,update]
+//	remove user <phone>
+//
+// It reports whether text was one of these commands.
+func (app *application) adminCommand(user service.User, text string) (string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	command := strings.ToLower(fields[0])
+	if len(fields) > 1 {
+		command += " " + strings.ToLower(fields[1])
+	}
+
+	isCommand := strings.EqualFold(strings.TrimSpace(text), "users") ||
+		command == "add user" || command == "remove user"
+	if !isCommand {
+		return "", false
+	}
+	if !user.Admin {
+		return "Only admins can manage users.", true
+	}
+
+	switch {
+	case strings.EqualFold(strings.TrimSpace(text), "users"):
+		var lines []string
+		for _, u := range app.users.List() {
+			lines = append(lines, u.String())
+		}
+		return strings.Join(lines, "\n"), true
+
+	case command == "add user":
+		if len(fields) < 4 {
+			return "Usage: add user <phone> <name> [admin] [groups=Kitchen,Bedroom] [actions=status,update]", true
+		}
+		u := service.User{Phone: fields[2]}
+		if !app.validUserAddress(u.Phone) {
+			return fmt.Sprintf("%s is not a phone number like +19875551234 or a chat address like telegram:123456789.", u.Phone), true
+		}
+		if u.Phone == app.config.userPhoneNumber {
+			return "The configured owner can't be changed.", true
+		}
+
+		var name []string
+		for _, f := range fields[3:] {
+			switch {
+			case strings.EqualFold(f, "admin"):
+				u.Admin = true
+			case strings.HasPrefix(strings.ToLower(f), "groups="):
+				groups, err := app.permittedGroups(splitList(f[len("groups="):]))
+				if err != nil {
+					return err.Error(), true
+				}
+				u.Groups = groups
+			case strings.HasPrefix(strings.ToLower(f), "actions="):
+				u.Actions = splitList(strings.ToLower(f[len("actions="):]))
+			default:
+				name = append(name, f)
+			}
+		}
+		u.Name = strings.Join(name, " ")
+		if u.Name == "" {
+			return "Please include a name for the user.", true
+		}
+
+		err := app.users.Add(u)
+		if err != nil {
+			app.logger.Error("error saving user", "phone", u.Phone, "error", err)
+			return "There was an error saving the user.", true
+		}
+		return fmt.Sprintf("Added %s.", u), true
+
+	default:
+		if len(fields) != 3 {
+			return "Usage: remove user <phone>", true
+		}
+		if fields[2] == app.config.userPhoneNumber {
+			return "The configured owner can't be removed.", true
+		}
+
+		u, err := app.users.Remove(fields[2])
+		if errors.Is(err, service.ErrUserNotFound) {
+			return fmt.Sprintf("There is no user %s.", fields[2]), true
+		}
+		if err != nil {
+			app.logger.Error("error removing user", "phone", fields[2], "error", err)
+			return "There was an error removing the user.", true
+		}
+		return fmt.Sprintf("Removed %s.", u), true
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+func TestAuthorizeAction(t *testing.T) {
+	kitchenOnly := service.User{Name: "Alice", Groups: []string{"home/Kitchen"}}
+	statusOnly := service.User{Name: "Bob", Actions: []string{"status"}}
+
+	tests := []struct {
+		name    string
+		user    service.User
+		clients []*homeClient
+		actions string
+		wantErr string
+	}{
+		{
+			name:    "permitted group",
+			user:    kitchenOnly,
+			clients: []*homeClient{newTestClient("home", "Kitchen", "Porch")},
+			actions: `{"type":"update","data":{"group":"kitchen","isOn":false}}`,
+		},
+		{
+			name:    "other group",
+			user:    kitchenOnly,
+			clients: []*homeClient{newTestClient("home", "Kitchen", "Porch")},
+			actions: `{"type":"update","data":{"group":"Porch","isOn":false}}`,
+			wantErr: "You aren't allowed to control Porch.",
+		},
+		{
+			name:    "permitted group qualified by a second client",
+			user:    kitchenOnly,
+			clients: []*homeClient{newTestClient("home", "Kitchen"), newTestClient("cabin", "Kitchen")},
+			actions: `{"type":"status","data":{"room":["home/Kitchen"]}}`,
+		},
+		{
+			name:    "same name on another client",
+			user:    kitchenOnly,
+			clients: []*homeClient{newTestClient("home", "Kitchen"), newTestClient("cabin", "Kitchen")},
+			actions: `{"type":"scene","data":{"group":"cabin/Kitchen","scene":"Relax"}}`,
+			wantErr: "You aren't allowed to control cabin/Kitchen.",
+		},
+		{
+			name:    "bare permission from before clients were told apart",
+			user:    service.User{Groups: []string{"kitchen"}},
+			clients: []*homeClient{newTestClient("home", "Kitchen"), newTestClient("cabin", "Kitchen")},
+			actions: `{"type":"update","data":{"group":"cabin/Kitchen","isOn":true}}`,
+		},
+		{
+			name:    "every group",
+			user:    kitchenOnly,
+			clients: []*homeClient{newTestClient("home", "Kitchen")},
+			actions: `{"type":"update","data":{"group":"all","isOn":false}}`,
+			wantErr: "You aren't allowed to control every group.",
+		},
+		{
+			name:    "nested in a schedule",
+			user:    kitchenOnly,
+			clients: []*homeClient{newTestClient("home", "Kitchen", "Porch")},
+			actions: `{"type":"schedule","data":{"in":"10m","description":"porch off","actions":[{"type":"update","data":{"group":"Porch","isOn":false}}]}}`,
+			wantErr: "You aren't allowed to control Porch.",
+		},
+		{
+			name:    "action kind",
+			user:    statusOnly,
+			clients: []*homeClient{newTestClient("home", "Kitchen")},
+			actions: `{"type":"update","data":{"group":"Kitchen","isOn":false}}`,
+			wantErr: "You aren't allowed to run update requests.",
+		},
+		{
+			name:    "rule kind",
+			user:    statusOnly,
+			clients: []*homeClient{newTestClient("home", "Kitchen")},
+			actions: `{"type":"list_rules","data":{}}`,
+			wantErr: "You aren't allowed to run rules requests.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{clients: newClientRegistry()}
+			for _, c := range tt.clients {
+				app.clients.add(c)
+			}
+			actions, err := parseActions(tt.actions)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = app.authorizeAction(tt.user, actions[0])
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got %v, want the action allowed", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserGroupsFollowTheirClient(t *testing.T) {
+	app := &application{clients: newClientRegistry()}
+	app.clients.add(newTestClient("home", "Kitchen", "Porch"))
+	user := service.User{Groups: []string{"home/Kitchen"}}
+
+	if got := app.userGroups(user).Names(); !reflect.DeepEqual(got, service.GroupNames{"Kitchen"}) {
+		t.Errorf("got groups %v, want Kitchen", got)
+	}
+
+	// A second client with a Kitchen qualifies the name, and the user keeps their own
+	app.clients.add(newTestClient("cabin", "Kitchen"))
+	if got := app.userGroups(user).Names(); !reflect.DeepEqual(got, service.GroupNames{"home/Kitchen"}) {
+		t.Errorf("got groups %v, want home/Kitchen", got)
+	}
+	scenes := app.userScenes(user)
+	if len(scenes) != 1 || scenes[0].Group != "home/Kitchen" {
+		t.Errorf("got scenes %+v, want the Relax scene of home/Kitchen", scenes)
+	}
+}
+
+func TestAdminCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		sender     string
+		text       string
+		want       string // prefix of the reply
+		wantUser   *service.User
+		notAdmin   bool
+		notHandled bool
+	}{
+		{
+			name:       "not a command",
+			text:       "kitchen off",
+			notHandled: true,
+		},
+		{
+			name:     "not an admin",
+			text:     "users",
+			notAdmin: true,
+			want:     "Only admins can manage users.",
+		},
+		{
+			name: "list users",
+			text: "users",
+			want: "Owner (fake:owner) admin",
+		},
+		{
+			name:     "add user with groups",
+			text:     "add user +14155550123 Alice Smith groups=kitchen actions=Status,update",
+			want:     "Added Alice Smith (+14155550123) groups: home/Kitchen actions: status, update.",
+			wantUser: &service.User{Phone: "+14155550123", Name: "Alice Smith", Groups: []string{"home/Kitchen"}, Actions: []string{"status", "update"}},
+		},
+		{
+			name:     "add admin on a chat transport",
+			text:     "add user fake:bob Bob admin",
+			want:     "Added Bob (fake:bob) admin.",
+			wantUser: &service.User{Phone: "fake:bob", Name: "Bob", Admin: true},
+		},
+		{
+			name: "unknown group",
+			text: "add user +14155550123 Alice groups=Garage",
+			want: "I don't know a group called 'Garage'. Known groups: Kitchen, Porch",
+		},
+		{
+			name: "invalid address",
+			text: "add user 4155550123 Alice",
+			want: "4155550123 is not a phone number",
+		},
+		{
+			name: "missing name",
+			text: "add user +14155550123 admin",
+			want: "Please include a name for the user.",
+		},
+		{
+			name: "change the owner",
+			text: "add user fake:owner Mallory",
+			want: "The configured owner can't be changed.",
+		},
+		{
+			name: "remove unknown user",
+			text: "remove user +14155550123",
+			want: "There is no user +14155550123.",
+		},
+		{
+			name: "remove the owner",
+			text: "remove user fake:owner",
+			want: "The configured owner can't be removed.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			app.clients.add(newTestClient("home", "Kitchen", "Porch"))
+			admin, _ := app.users.Lookup(testOwner)
+			if tt.notAdmin {
+				admin.Admin = false
+			}
+
+			reply, ok := app.adminCommand(admin, tt.text)
+			if ok == tt.notHandled {
+				t.Fatalf("got handled %t, want %t", ok, !tt.notHandled)
+			}
+			if !strings.HasPrefix(reply, tt.want) {
+				t.Errorf("got reply %q, want it to start with %q", reply, tt.want)
+			}
+
+			if tt.wantUser != nil {
+				u, ok := app.users.Lookup(tt.wantUser.Phone)
+				if !ok || !reflect.DeepEqual(u, *tt.wantUser) {
+					t.Errorf("got user %+v, want %+v", u, *tt.wantUser)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminCommandRemoveUser(t *testing.T) {
+	app, _ := newTestApp(t)
+	if err := app.users.Add(service.User{Phone: "+14155550123", Name: "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+	admin, _ := app.users.Lookup(testOwner)
+
+	reply, ok := app.adminCommand(admin, "remove user +14155550123")
+	if !ok || reply != "Removed Alice (+14155550123)." {
+		t.Errorf("got %q, %t; want Alice removed", reply, ok)
+	}
+	if _, ok := app.users.Lookup("+14155550123"); ok {
+		t.Error("the user is still registered")
+	}
+}
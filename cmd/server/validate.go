@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+)
+
+// validateActions checks actions against the groups and scenes the user can see
+// before anything is sent to a client, and rewrites the groups and scenes they name to
+// their canonical names. The error explains the first problem in words fit to text
+// back to the user, and to feed back to the model for a retry.
+func (app *application) validateActions(actions []service.Intent, groups service.Groups, scenes service.Scenes) error {
+	for i := range actions {
+		err := app.validateAction(&actions[i], groups, scenes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *application) validateAction(action *service.Intent, groups service.Groups, scenes service.Scenes) error {
+	switch action.Type {
+	case "status":
+		statusData, err := actionData[GPTStatusData](*action)
+		if err != nil {
+			return fmt.Errorf("The status request is malformed: %w.", err)
+		}
+		rooms := make(service.GroupNames, len(statusData.Rooms))
+		for i, name := range statusData.Rooms {
+			rooms[i], err = app.validGroup(name, groups, false)
+			if err != nil {
+				return err
+			}
+		}
+		statusData.Rooms = rooms
+		action.Data = statusData
+
+	case "update":
+		updateRequest, err := actionData[GPTUpdateRequest](*action)
+		if err != nil {
+			return fmt.Errorf("The update request is malformed: %w.", err)
+		}
+		err = app.validateUpdate(&updateRequest, groups)
+		if err != nil {
+			return err
+		}
+		action.Data = updateRequest
+
+	case "scene":
+		sceneRequest, err := actionData[GPTSceneRequest](*action)
+		if err != nil {
+			return fmt.Errorf("The scene request is malformed: %w.", err)
+		}
+		group, err := app.validGroup(sceneRequest.Group, groups, false)
+		if err != nil {
+			return err
+		}
+		scene, ok := scenes.Lookup(group, sceneRequest.Scene)
+		if !ok {
+			msg := fmt.Sprintf("I don't know a scene called '%s' for %s.", sceneRequest.Scene, group)
+			if names := scenes.ForGroup(group).Names(); len(names) > 0 {
+				msg += " Known scenes: " + strings.Join(names, ", ")
+			}
+			return fmt.Errorf("%s", msg)
+		}
+		sceneRequest.Group, sceneRequest.Scene = group, scene.Name
+		action.Data = sceneRequest
+
+	case "schedule", "create_rule":
+		// The nested actions share their backing array with the request, so they are
+		// rewritten in place
+		nested, err := nestedActionsOf(*action)
+		if err != nil {
+			return fmt.Errorf("The %s request is malformed: %w.", action.Type, err)
+		}
+		return app.validateActions(nested, groups, scenes)
+
+	case "clarify", "list_schedules", "cancel_schedule", "list_rules", "pause_rule", "resume_rule", "delete_rule":
+
+	default:
+		return fmt.Errorf("I don't know how to handle a '%s' request.", action.Type)
+	}
+	return nil
+}
+
+// validateUpdate checks the target, ranges and consistency of an update request and
+// rewrites its group to the canonical name.
+func (app *application) validateUpdate(req *GPTUpdateRequest, groups service.Groups) error {
+	group, err := app.validGroup(req.Group, groups, true)
+	if err != nil {
+		return err
+	}
+	req.Group = group
+
+	if !req.IsOn && (req.Brightness != nil || req.BrightnessDelta != nil || req.ColorTemp != nil || req.Color != nil || req.Effect != nil) {
+		return fmt.Errorf("I can't turn %s off and change its brightness, color or effect at the same time.", group)
+	}
+	if req.Brightness != nil && (*req.Brightness < 0 || *req.Brightness > service.MaxBrightness) {
+		return fmt.Errorf("Brightness must be between 0 and %d, not %d.", service.MaxBrightness, *req.Brightness)
+	}
+	if req.BrightnessDelta != nil {
+		if req.Brightness != nil {
+			return fmt.Errorf("I can't set the brightness of %s and change it by a percentage at the same time.", group)
+		}
+		if *req.BrightnessDelta < -100 || *req.BrightnessDelta > 100 {
+			return fmt.Errorf("A brightness change must be between -100%% and 100%%, not %d%%.", *req.BrightnessDelta)
+		}
+	}
+	if req.ColorTemp != nil {
+		if req.Color != nil {
+			return fmt.Errorf("I can't set both a color temperature and a color for %s.", group)
+		}
+		if *req.ColorTemp < service.MinColorTempKelvin || *req.ColorTemp > service.MaxColorTempKelvin {
+			return fmt.Errorf("Color temperature must be between %dK and %dK, not %dK.",
+				service.MinColorTempKelvin, service.MaxColorTempKelvin, *req.ColorTemp)
+		}
+	}
+	if req.Color != nil {
+		if _, ok := service.LookupColor(*req.Color); !ok {
+			return fmt.Errorf("I don't know the color '%s'. Known colors: %s", *req.Color, strings.Join(service.ColorNames(), ", "))
+		}
+	}
+	if req.Effect != nil && !slices.Contains(service.Effects, strings.ToLower(*req.Effect)) {
+		return fmt.Errorf("I don't know the effect '%s'. Known effects: %s", *req.Effect, strings.Join(service.Effects, ", "))
+	}
+	return nil
+}
+
+// validGroup returns the display name of the group name refers to, which must be one
+// of groups or, when allowAll is set, the "all" target.
+func (app *application) validGroup(name string, groups service.Groups, allowAll bool) (string, error) {
+	if allowAll && strings.EqualFold(name, allGroupsTarget) {
+		return allGroupsTarget, nil
+	}
+
+	for _, g := range groups {
+		if strings.EqualFold(g.Name, name) {
+			return g.Name, nil
+		}
+	}
+	// Bridge names still work when only one client has a group by that name
+	if cg, ok := app.clients.lookupGroup(name); ok && slices.ContainsFunc(groups, func(g service.Group) bool { return g.Name == cg.Name }) {
+		return cg.Name, nil
+	}
+
+	return "", fmt.Errorf("I don't know a group called '%s'. Known groups: %s", name, strings.Join(groups.Names(), ", "))
+}
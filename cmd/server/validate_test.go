@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	"github.com/amimof/huego"
+)
+
+// newTestClient returns an offline home client that reported the named groups,
+// each with a "Relax" scene.
+func newTestClient(id string, names ...string) *homeClient {
+	c := newHomeClient(id, nil)
+	var groups service.Groups
+	var scenes service.Scenes
+	for _, name := range names {
+		groups = append(groups, service.Group{Group: huego.Group{Name: name, State: &huego.State{On: true, Bri: 254}}})
+		scenes = append(scenes, service.Scene{ID: name + "-relax", Name: "Relax", Group: name})
+	}
+	c.setGroups(groups)
+	c.setScenes(scenes)
+	return c
+}
+
+func TestValidateActionsCanonicalNames(t *testing.T) {
+	app := &application{clients: newClientRegistry()}
+	app.clients.add(newTestClient("home", "Kitchen", "Living room"))
+	app.clients.add(newTestClient("cabin", "Kitchen"))
+	groups, scenes := app.clients.displayGroups(), app.clients.displayScenes()
+
+	tests := []struct {
+		name    string
+		actions string
+		want    []service.Intent
+	}{
+		{
+			name:    "status",
+			actions: `{"type":"status","data":{"room":["living ROOM","home/kitchen"]}}`,
+			want:    []service.Intent{{Type: "status", Data: GPTStatusData{Rooms: service.GroupNames{"Living room", "home/Kitchen"}}}},
+		},
+		{
+			name:    "update",
+			actions: `{"type":"update","data":{"group":"CABIN/KITCHEN","isOn":false}}`,
+			want:    []service.Intent{{Type: "update", Data: GPTUpdateRequest{Group: "cabin/Kitchen"}}},
+		},
+		{
+			name:    "every group",
+			actions: `{"type":"update","data":{"group":"All","isOn":false}}`,
+			want:    []service.Intent{{Type: "update", Data: GPTUpdateRequest{Group: allGroupsTarget}}},
+		},
+		{
+			name:    "scene",
+			actions: `{"type":"scene","data":{"group":"living room","scene":"relax"}}`,
+			want:    []service.Intent{{Type: "scene", Data: GPTSceneRequest{Group: "Living room", Scene: "Relax"}}},
+		},
+		{
+			name: "scheduled",
+			actions: `{"type":"schedule","data":{"in":"1h","description":"Off","actions":[
+				{"type":"update","data":{"group":"living room","isOn":false}}]}}`,
+			want: []service.Intent{{Type: "schedule", Data: GPTScheduleRequest{In: "1h", Description: "Off", Actions: nestedActions{
+				{Type: "update", Data: GPTUpdateRequest{Group: "Living room"}},
+			}}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := parseActions(tt.actions)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = app.validateActions(actions, groups, scenes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actions, tt.want) {
+				t.Errorf("got %#v, want %#v", actions, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateActionsStatusCase(t *testing.T) {
+	app := &application{clients: newClientRegistry()}
+	app.clients.add(newTestClient("home", "Kitchen"))
+	groups := app.clients.displayGroups()
+
+	actions, err := parseActions(`{"type":"status","data":{"room":["kitchen"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.validateActions(actions, groups, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The status reply only describes groups named exactly as they are known
+	data, err := actionData[GPTStatusData](actions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := groups.GroupStatusMessage(data.Rooms); got != "Kitchen: On, 100%\n" {
+		t.Errorf("got status %q, want the kitchen's", got)
+	}
+}
+
+func TestValidateActionsErrors(t *testing.T) {
+	app := &application{clients: newClientRegistry()}
+	app.clients.add(newTestClient("home", "Kitchen"))
+	groups, scenes := app.clients.displayGroups(), app.clients.displayScenes()
+
+	tests := []struct {
+		name    string
+		actions string
+		want    string
+	}{
+		{"unknown group", `{"type":"update","data":{"group":"Den","isOn":true}}`, "I don't know a group called 'Den'"},
+		{"unknown status group", `{"type":"status","data":{"room":["Den"]}}`, "I don't know a group called 'Den'"},
+		{"status of every group", `{"type":"status","data":{"room":["all"]}}`, "I don't know a group called 'all'"},
+		{"unknown scene", `{"type":"scene","data":{"group":"kitchen","scene":"Party"}}`, "I don't know a scene called 'Party' for Kitchen. Known scenes: Relax"},
+		{"off with brightness", `{"type":"update","data":{"group":"kitchen","isOn":false,"brightness":100}}`, "I can't turn Kitchen off"},
+		{"nested unknown group", `{"type":"create_rule","data":{"cron":"0 7 * * *","description":"Den","actions":[
+			{"type":"update","data":{"group":"Den","isOn":true}}]}}`, "I don't know a group called 'Den'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := parseActions(tt.actions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = app.validateActions(actions, groups, scenes)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -3,11 +3,15 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	"github.com/amimof/huego"
 	"github.com/gorilla/websocket"
 )
 
@@ -21,11 +25,31 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// GroupsStateMessage represents the structure of the group state messages.
+// wsAuthTimeout is how long a client has to send its auth message after the upgrade.
+const wsAuthTimeout = 10 * time.Second
+
+// wsRequestTimeout is how long the server waits for a client to reply to a request.
+const wsRequestTimeout = 5 * time.Second
+
+// WSAuthMessage represents the first message a client sends when it did not
+// authenticate with an Authorization header.
+type WSAuthMessage struct {
+	Type string `json:"type"`
+	Data struct {
+		Token    string `json:"token"`
+		ClientID string `json:"clientId,omitempty"`
+	} `json:"data"`
+}
+
+// GroupsStateMessage represents the structure of the group state messages. Lights,
+// keyed by light ID, is optional and tells whether the lights of each group are
+// reachable, which the group state alone doesn't.
 type GroupsStateMessage struct {
 	Type string `json:"type"`
 	Data struct {
-		Groups service.Groups `json:"groups"`
+		Groups service.Groups         `json:"groups"`
+		Scenes service.Scenes         `json:"scenes,omitempty"`
+		Lights map[string]huego.Light `json:"lights,omitempty"`
 	} `json:"data"`
 }
 
@@ -35,6 +59,14 @@ func (gs *GroupsStateMessage) Unmarshal(data []byte) error {
 
 // handleWSConnections handles WebSocket connections and processes incoming messages.
 func (app *application) handleWSConnections(w http.ResponseWriter, r *http.Request) {
+	// A token sent in the Authorization header is checked before upgrading.
+	token, hasHeader := bearerToken(r)
+	if hasHeader && !app.validAuthToken(token) {
+		app.logger.Warn("rejected websocket connection with invalid token", "remote_addr", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	// Upgrade the HTTP connection to a WebSocket connection.
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -43,49 +75,119 @@ func (app *application) handleWSConnections(w http.ResponseWriter, r *http.Reque
 	}
 	defer conn.Close()
 
-	// Set the WebSocket connection for the application.
-	app.wsConnection = conn
-	log.Println("Client connected:", r.RemoteAddr)
+	// The client identifies itself with a header or query parameter, or in its auth message.
+	clientID := r.Header.Get("X-Client-ID")
+	if clientID == "" {
+		clientID = r.URL.Query().Get("client_id")
+	}
+
+	// Without the header the client must authenticate with its first message.
+	if !hasHeader {
+		authID, err := app.authenticateWSConnection(conn)
+		if err != nil {
+			app.logger.Warn("rejected websocket connection", "remote_addr", r.RemoteAddr, "error", err)
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "unauthorized"),
+				time.Now().Add(time.Second))
+			return
+		}
+		if authID != "" {
+			clientID = authID
+		}
+	}
+	if clientID == "" {
+		clientID = defaultClientID
+	}
+
+	// Register the client, closing any earlier connection that used the same ID.
+	client := newHomeClient(clientID, conn)
+	if old := app.clients.add(client); old != nil && old.connected() {
+		app.logger.Warn("replacing existing connection for client", "client_id", clientID)
+		old.disconnect()
+	}
+	defer func() {
+		app.clients.remove(client)
+		app.events.publish(eventClientDisconnected, envelope{"clientId": clientID})
+	}()
+	app.logger.Info("client connected", "client_id", clientID, "remote_addr", r.RemoteAddr)
+	app.events.publish(eventClientConnected, envelope{"clientId": clientID})
 
 	// Loop to read and process incoming messages from the client.
 	for {
-		fmt.Println("Waiting for message from client")
 		var msg JSONMessage
 		// Read a JSON message from the client.
 		err := conn.ReadJSON(&msg)
 		if err != nil {
-			log.Println("Error reading message from client:", err)
+			app.logger.Info("error reading message from client", "client_id", clientID, "error", err)
 			break
 		}
 
 		// Dispatch the message based on its type.
-		app.dispatchMessage(msg)
+		app.dispatchMessage(client, msg)
+	}
+	app.logger.Info("client disconnected", "client_id", clientID)
+}
+
+// authenticateWSConnection waits up to wsAuthTimeout for an auth message, checks its
+// token and returns the client ID it carries, if any.
+func (app *application) authenticateWSConnection(conn *websocket.Conn) (string, error) {
+	err := conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	if err != nil {
+		return "", err
+	}
+
+	var msg WSAuthMessage
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		return "", fmt.Errorf("reading auth message: %w", err)
 	}
-	log.Println("Client disconnected")
+
+	if msg.Type != "auth" {
+		return "", fmt.Errorf("expected auth message, got %q", msg.Type)
+	}
+	if !app.validAuthToken(msg.Data.Token) {
+		return "", fmt.Errorf("invalid auth token")
+	}
+
+	// Clear the deadline so the connection can idle between messages.
+	return msg.Data.ClientID, conn.SetReadDeadline(time.Time{})
 }
 
-// dispatchMessage routes the message to the appropriate handler or channel.
-func (app *application) dispatchMessage(msg JSONMessage) {
-	app.responseMu.Lock()
-	defer app.responseMu.Unlock()
-
-	if ch, exists := app.responseMap[msg.Type]; exists {
-		ch <- msg
-	} else {
-		switch msg.Type {
-		case "group_state":
-			err := app.GroupStateMessageHandler(msg)
+// dispatchMessage routes replies to their waiting request and everything else by type.
+func (app *application) dispatchMessage(client *homeClient, msg JSONMessage) {
+	if client.resolve(msg) {
+		return
+	}
+
+	// A reply whose request already timed out still carries fresh state, but the
+	// change was asked for, so it is saved without notifying anyone
+	if msg.ID != "" {
+		app.logger.Info("late reply from client", "client_id", client.id, "type", msg.Type, "id", msg.ID)
+		if msg.Type == "group_state" {
+			err := app.GroupStateMessageHandler(client, msg, false)
 			if err != nil {
 				app.logger.Error("Error handling group state message:", "error", err)
 			}
-		default:
-			app.logger.Warn("unknown message type:", "type", msg.Type)
 		}
+		return
+	}
+
+	// Only messages without an ID were pushed by the client on its own
+	switch msg.Type {
+	case "group_state":
+		err := app.GroupStateMessageHandler(client, msg, true)
+		if err != nil {
+			app.logger.Error("Error handling group state message:", "error", err)
+		}
+	default:
+		app.logger.Warn("unknown message type:", "type", msg.Type, "id", msg.ID)
 	}
 }
 
-// GroupStateMessageHandler processes group state messages and updates the application state.
-func (app *application) GroupStateMessageHandler(msg JSONMessage) error {
+// GroupStateMessageHandler processes group state messages and updates the client's state.
+// unsolicited is true when the client pushed the state on its own rather than in reply
+// to a request.
+func (app *application) GroupStateMessageHandler(client *homeClient, msg JSONMessage, unsolicited bool) error {
 	// Convert the generic Data field to JSON.
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -98,57 +200,145 @@ func (app *application) GroupStateMessageHandler(msg JSONMessage) error {
 		return err
 	}
 
-	groups := service.Groups(msgData.Data.Groups)
-	// Update the application state with the new groups data.
-	app.groupsState = &groups
-	app.groupNames = []string{}
-	for _, group := range groups {
-		app.groupNames = append(app.groupNames, group.Name)
-	}
+	// Update the client state with the new groups data.
+	msgData.Data.Groups.SetReachable(msgData.Data.Lights)
+	old := client.groups()
+	client.setGroups(msgData.Data.Groups)
+	client.setScenes(msgData.Data.Scenes)
+	app.groupsChanged(client, old, unsolicited)
 	return nil
 }
 
-func (app *application) SetGroupsStateField() error {
-	msg := JSONMessage{
-		Type: "status",
-		Data: nil,
+// groupsChanged persists the client's state, then records and publishes how its groups
+// differ from old, the client's groups before they were updated. Unsolicited changes
+// to watched groups are also texted to the user.
+func (app *application) groupsChanged(client *homeClient, old service.Groups, unsolicited bool) {
+	app.saveClientState(client)
+
+	current := client.groups()
+	changes := service.DiffGroups(old, current)
+	if len(changes) == 0 {
+		return
 	}
 
-	responseChan := make(chan JSONMessage)
+	// Name the groups the way the user sees them
+	for i := range changes {
+		changes[i].Group = app.clients.displayName(client, changes[i].Group)
+	}
 
-	app.responseMu.Lock()
-	app.responseMap["group_state"] = responseChan
-	app.responseMu.Unlock()
+	app.recordHistory(service.HistoryStateChange, "", client.id, "", envelope{"unsolicited": unsolicited, "changes": changes})
+	app.events.publish(eventGroupState, envelope{
+		"clientId":    client.id,
+		"unsolicited": unsolicited,
+		"changes":     changes,
+		"groups":      current.Changed(old),
+	})
 
-	err := app.wsConnection.WriteJSON(msg)
-	if err != nil {
-		return err
+	if unsolicited {
+		app.notifyGroupChanges(changes)
 	}
+}
 
-	select {
-	case response := <-responseChan:
-		// Handle the response
-		var groupsMessage GroupsStateMessage
-		data, err := json.Marshal(response)
-		if err != nil {
-			return err
+// notifyGroupChanges texts the user about on/off and reachability changes to the
+// groups listed in the notifyGroups config. The text is sent in the background, since
+// this runs in the client's read loop and must not hold up the replies it reads.
+func (app *application) notifyGroupChanges(changes []service.GroupChange) {
+	var lines []string
+	for _, c := range changes {
+		if c.Field != service.ChangeOn && c.Field != service.ChangeReachable {
+			continue
 		}
-
-		err = groupsMessage.Unmarshal(data)
-		if err != nil {
-			return err
+		if !slices.ContainsFunc(app.config.notifyGroups, func(name string) bool {
+			return strings.EqualFold(name, c.Group)
+		}) {
+			continue
 		}
+		lines = append(lines, fmt.Sprintf("%s at %s", c, time.Now().Format("15:04")))
+	}
+
+	if len(lines) > 0 {
+		go app.sendTextMessage(strings.Join(lines, "\n"))
+	}
+}
+
+// saveClientState persists the client's state so the server can start warm.
+func (app *application) saveClientState(client *homeClient) {
+	err := app.store.SaveClientState(client.state())
+	if err != nil {
+		app.logger.Error("error saving client state", "client_id", client.id, "error", err)
+	}
+}
+
+// groupsStateError records the clients that failed to report their group state, by
+// client ID. Their groups keep the last state they reported.
+type groupsStateError map[string]error
+
+func (e groupsStateError) Error() string {
+	ids := e.clientIDs()
+	msgs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		msgs = append(msgs, fmt.Sprintf("client %s: %s", id, e[id]))
+	}
+	return strings.Join(msgs, "; ")
+}
 
-		app.groupsState = &groupsMessage.Data.Groups
+// clientIDs returns the IDs of the failed clients in order.
+func (e groupsStateError) clientIDs() []string {
+	ids := make([]string, 0, len(e))
+	for id := range e {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
 
-	case <-time.After(5 * time.Second):
-		// Timeout after 5 seconds
-		app.logger.Error("timeout waiting for group_state response")
-		return fmt.Errorf("timeout waiting for group_state response")
+// SetGroupsStateField asks every connected client for its current group state in
+// parallel. A client that fails doesn't hold up the others; the failures are returned
+// as a groupsStateError.
+func (app *application) SetGroupsStateField() error {
+	clients := app.clients.connected()
+	if len(clients) == 0 {
+		return errNoClients
+	}
 
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := groupsStateError{}
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *homeClient) {
+			defer wg.Done()
+			err := app.requestGroupsState(client)
+			if err != nil {
+				mu.Lock()
+				failed[client.id] = err
+				mu.Unlock()
+			}
+		}(client)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return failed
 	}
-	app.responseMu.Lock()
-	delete(app.responseMap, "group_state")
-	app.responseMu.Unlock()
 	return nil
 }
+
+// requestGroupsState asks a single client for its group state and waits for the reply.
+func (app *application) requestGroupsState(client *homeClient) error {
+	msg := JSONMessage{
+		Type: "status",
+		Data: nil,
+	}
+
+	response, err := client.request(msg, wsRequestTimeout)
+	if err != nil {
+		app.logger.Error("error waiting for group_state response", "client_id", client.id, "error", err)
+		return err
+	}
+
+	if response.Type != "group_state" {
+		return fmt.Errorf("expected group_state reply, got %q", response.Type)
+	}
+	return app.GroupStateMessageHandler(client, response, false)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TimEngleSF/remote-hue-server/internal/service"
+	"github.com/amimof/huego"
+)
+
+// blockingTransport holds every message until release is closed.
+type blockingTransport struct {
+	service.FakeTransport
+	release chan struct{}
+}
+
+func (b *blockingTransport) Notify(id, text string) error {
+	<-b.release
+	return b.FakeTransport.Notify(id, text)
+}
+
+// groupStateMessage returns a group_state message for a single group with one light.
+func groupStateMessage(name string, on, reachable bool) JSONMessage {
+	return JSONMessage{
+		Type: "group_state",
+		Data: map[string]any{
+			"groups": service.Groups{{Group: huego.Group{Name: name, Lights: []string{"1"}, State: &huego.State{On: on, Bri: 254}}}},
+			"lights": map[string]huego.Light{"1": {State: &huego.State{On: on, Reachable: reachable}}},
+		},
+	}
+}
+
+func TestDispatchGroupStateNotifies(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.config.notifyGroups = []string{"porch"}
+	slow := &blockingTransport{release: make(chan struct{})}
+	app.transports[service.TransportFake] = slow
+
+	client := newHomeClient("home", nil)
+	app.clients.add(client)
+	app.dispatchMessage(client, groupStateMessage("Porch", false, true))
+
+	// The client's read loop carries on while the text is still being sent
+	done := make(chan struct{})
+	go func() {
+		app.dispatchMessage(client, groupStateMessage("Porch", true, false))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatching group state waited for the notification to be sent")
+	}
+
+	close(slow.release)
+	var sent []service.SentMessage
+	for deadline := time.Now().Add(time.Second); len(sent) == 0 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+		sent = slow.Sent()
+	}
+	if len(sent) != 1 {
+		t.Fatalf("got %d messages, want one notification", len(sent))
+	}
+	if !strings.Contains(sent[0].Text, "Porch turned on") || !strings.Contains(sent[0].Text, "Porch became unreachable") {
+		t.Errorf("got %q, want the porch turning on and becoming unreachable", sent[0].Text)
+	}
+	if sent[0].To != "owner" {
+		t.Errorf("sent to %q, want the owner", sent[0].To)
+	}
+}
+
+func TestDispatchGroupStateReplyDoesNotNotify(t *testing.T) {
+	app, fake := newTestApp(t)
+	app.config.notifyGroups = []string{"Porch"}
+
+	client := newHomeClient("home", nil)
+	app.clients.add(client)
+	app.dispatchMessage(client, groupStateMessage("Porch", false, true))
+
+	// A late reply to a request carries an ID and was asked for
+	reply := groupStateMessage("Porch", true, true)
+	reply.ID = "late"
+	app.dispatchMessage(client, reply)
+
+	time.Sleep(20 * time.Millisecond)
+	if sent := fake.Sent(); len(sent) != 0 {
+		t.Errorf("got %v, want no notification", sent)
+	}
+	if g := client.groups(); len(g) != 1 || !g[0].State.On {
+		t.Errorf("got groups %v, want the porch on", g)
+	}
+}
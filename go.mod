@@ -3,6 +3,8 @@ module github.com/TimEngleSF/remote-hue-server
 go 1.22.1
 
 require (
+	github.com/amimof/huego v1.2.1
+	github.com/gorilla/websocket v1.5.3
 	github.com/joho/godotenv v1.5.1
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/sashabaranov/go-openai v1.26.1
@@ -10,8 +12,6 @@ require (
 )
 
 require (
-	github.com/amimof/huego v1.2.1 // indirect
 	github.com/golang/mock v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 )
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Color temperature limits supported by Hue bulbs, in kelvin and mired.
+const (
+	MinColorTempKelvin = 2000
+	MaxColorTempKelvin = 6500
+	MinMired           = 153
+	MaxMired           = 500
+)
+
+// Effects supported by the Hue bridge.
+var Effects = []string{"none", "colorloop"}
+
+// Color is a named color expressed in the formats the Hue bridge accepts.
+type Color struct {
+	Name string
+	XY   []float32
+	Hue  uint16
+	Sat  uint8
+}
+
+// namedColors maps color names to their sRGB values.
+var namedColors = map[string][3]uint8{
+	"red":       {255, 0, 0},
+	"orange":    {255, 140, 0},
+	"yellow":    {255, 220, 0},
+	"green":     {0, 255, 0},
+	"cyan":      {0, 255, 255},
+	"blue":      {0, 0, 255},
+	"purple":    {150, 0, 255},
+	"magenta":   {255, 0, 255},
+	"pink":      {255, 105, 180},
+	"white":     {255, 255, 255},
+	"turquoise": {64, 224, 208},
+	"lavender":  {190, 160, 255},
+}
+
+// ColorNames returns the supported color names in alphabetical order.
+func ColorNames() []string {
+	names := make([]string, 0, len(namedColors))
+	for name := range namedColors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupColor returns the named color, ignoring case and surrounding space.
+func LookupColor(name string) (Color, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	rgb, ok := namedColors[name]
+	if !ok {
+		return Color{}, false
+	}
+
+	hue, sat := rgbToHueSat(rgb)
+	return Color{Name: name, XY: rgbToXY(rgb), Hue: hue, Sat: sat}, true
+}
+
+// NearestColorName returns the name of the supported color closest to xy.
+func NearestColorName(xy []float32) string {
+	if len(xy) != 2 {
+		return ""
+	}
+
+	best, bestDist := "", math.MaxFloat64
+	for _, name := range ColorNames() {
+		c := rgbToXY(namedColors[name])
+		dist := math.Hypot(float64(c[0]-xy[0]), float64(c[1]-xy[1]))
+		if dist < bestDist {
+			best, bestDist = name, dist
+		}
+	}
+	return best
+}
+
+// KelvinToMired converts a color temperature in kelvin to the mired value the bridge
+// uses, clamped to the range Hue bulbs support.
+func KelvinToMired(kelvin int) uint16 {
+	if kelvin <= 0 {
+		return MaxMired
+	}
+	mired := int(math.Round(1e6 / float64(kelvin)))
+	return uint16(min(max(mired, MinMired), MaxMired))
+}
+
+// MiredToKelvin converts a mired value reported by the bridge to kelvin.
+func MiredToKelvin(mired uint16) int {
+	if mired == 0 {
+		return 0
+	}
+	return int(math.Round(1e6 / float64(mired)))
+}
+
+// rgbToXY converts an sRGB color to CIE 1931 xy coordinates using the wide gamut
+// conversion recommended by Philips.
+func rgbToXY(rgb [3]uint8) []float32 {
+	linear := func(c uint8) float64 {
+		v := float64(c) / 255
+		if v > 0.04045 {
+			return math.Pow((v+0.055)/1.055, 2.4)
+		}
+		return v / 12.92
+	}
+	r, g, b := linear(rgb[0]), linear(rgb[1]), linear(rgb[2])
+
+	x := r*0.664511 + g*0.154324 + b*0.162028
+	y := r*0.283881 + g*0.668433 + b*0.047685
+	z := r*0.000088 + g*0.072310 + b*0.986039
+
+	sum := x + y + z
+	if sum == 0 {
+		return []float32{0, 0}
+	}
+	return []float32{float32(math.Round(x/sum*10000) / 10000), float32(math.Round(y/sum*10000) / 10000)}
+}
+
+// rgbToHueSat converts an sRGB color to the bridge's hue (0-65535) and saturation (0-254) scales.
+func rgbToHueSat(rgb [3]uint8) (uint16, uint8) {
+	r, g, b := float64(rgb[0])/255, float64(rgb[1])/255, float64(rgb[2])/255
+	hi, lo := max(r, g, b), min(r, g, b)
+	delta := hi - lo
+
+	var hue float64
+	switch {
+	case delta == 0:
+		hue = 0
+	case hi == r:
+		hue = math.Mod((g-b)/delta, 6)
+	case hi == g:
+		hue = (b-r)/delta + 2
+	default:
+		hue = (r-g)/delta + 4
+	}
+	hue *= 60
+	if hue < 0 {
+		hue += 360
+	}
+
+	var sat float64
+	if hi > 0 {
+		sat = delta / hi
+	}
+	return uint16(math.Round(hue / 360 * 65535)), uint8(math.Round(sat * 254))
+}
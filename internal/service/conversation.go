@@ -0,0 +1,70 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// Turn is an earlier message from a sender and the actions it was understood as.
+type Turn struct {
+	Text    string
+	Actions string
+	Time    time.Time
+}
+
+// Conversations remembers the recent turns of each sender so follow-ups such as "a bit
+// brighter" can be understood. Memory is kept for the last window turns and forgotten
+// after expiry without a new message.
+type Conversations struct {
+	window int
+	expiry time.Duration
+
+	mu    sync.Mutex
+	turns map[string][]Turn
+}
+
+// NewConversations returns conversation memory of window turns per sender. A window
+// of 0 remembers nothing.
+func NewConversations(window int, expiry time.Duration) *Conversations {
+	return &Conversations{
+		window: window,
+		expiry: expiry,
+		turns:  make(map[string][]Turn),
+	}
+}
+
+// History returns the sender's remembered turns, oldest first.
+func (c *Conversations) History(sender string, now time.Time) []Turn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	turns := c.turns[sender]
+	if len(turns) > 0 && c.expiry > 0 && now.Sub(turns[len(turns)-1].Time) > c.expiry {
+		delete(c.turns, sender)
+		return nil
+	}
+	return append([]Turn(nil), turns...)
+}
+
+// Add remembers a turn of the sender, forgetting the oldest beyond the window.
+func (c *Conversations) Add(sender string, turn Turn) {
+	if c.window <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	turns := append(c.turns[sender], turn)
+	if len(turns) > c.window {
+		turns = turns[len(turns)-c.window:]
+	}
+	c.turns[sender] = turns
+}
+
+// Reset forgets the sender's conversation.
+func (c *Conversations) Reset(sender string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.turns, sender)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField is the set of values a single cron field matches.
+type cronField struct {
+	values map[int]bool
+	any    bool
+}
+
+func (f cronField) matches(v int) bool {
+	return f.any || f.values[v]
+}
+
+// CronSpec is a parsed five field cron expression: minute, hour, day of month, month
+// and day of week. Each field accepts "*", numbers, ranges such as "1-5", lists such as
+// "1,3,5" and steps such as "*/15". Day of week runs from 0 (Sunday) to 6, and 7 is
+// also Sunday.
+type CronSpec struct {
+	minute, hour, dom, month, dow cronField
+}
+
+// ParseCron parses a five field cron expression.
+func ParseCron(expr string) (CronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return CronSpec{}, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	var spec CronSpec
+	var err error
+	if spec.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return CronSpec{}, fmt.Errorf("minute: %w", err)
+	}
+	if spec.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return CronSpec{}, fmt.Errorf("hour: %w", err)
+	}
+	if spec.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return CronSpec{}, fmt.Errorf("day of month: %w", err)
+	}
+	if spec.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return CronSpec{}, fmt.Errorf("month: %w", err)
+	}
+	if spec.dow, err = parseWeekdays(fields[4]); err != nil {
+		return CronSpec{}, err
+	}
+	return spec, nil
+}
+
+// parseWeekdays parses a cron day of week field.
+func parseWeekdays(field string) (cronField, error) {
+	dow, err := parseCronField(field, 0, 7)
+	if err != nil {
+		return cronField{}, fmt.Errorf("day of week: %w", err)
+	}
+	if dow.values[7] {
+		dow.values[0] = true
+	}
+	return dow, nil
+}
+
+func parseCronField(field string, lo, hi int) (cronField, error) {
+	if field == "*" {
+		return cronField{any: true}, nil
+	}
+
+	f := cronField{values: make(map[int]bool)}
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return cronField{}, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		start, end := lo, hi
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			start, err = strconv.Atoi(from)
+			if err != nil {
+				return cronField{}, fmt.Errorf("invalid value %q", from)
+			}
+			end = start
+			if isRange {
+				end, err = strconv.Atoi(to)
+				if err != nil {
+					return cronField{}, fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				end = hi
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return cronField{}, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+
+		for v := start; v <= end; v += step {
+			f.values[v] = true
+		}
+	}
+	return f, nil
+}
+
+// Next returns the first time after t that matches the spec, in t's location. It
+// returns the zero time if nothing matches within the next five years. Times skipped
+// when clocks spring forward never match, and times repeated when they fall back
+// match only the first time.
+func (spec CronSpec) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !spec.month.matches(int(t.Month())) {
+			t = later(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !spec.matchesDay(t) {
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !spec.hour.matches(t.Hour()) {
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !spec.minute.matches(t.Minute()) {
+			next := t.Add(time.Minute)
+			// Skip the wall clock times repeated when the clocks fall back
+			_, before := t.Zone()
+			if _, after := next.Zone(); after < before {
+				next = next.Add(time.Duration(before-after) * time.Second)
+			}
+			t = next
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// later returns next, the start of the following month, day or hour of t, when it is
+// after t. time.Date turns a wall clock time skipped when the clocks spring forward
+// into an earlier one, so the search then moves on to the next hour instead.
+func later(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
+
+// matchesDay follows cron's rule that when both day fields are restricted a day
+// matching either of them matches.
+func (spec CronSpec) matchesDay(t time.Time) bool {
+	domMatch := spec.dom.matches(t.Day())
+	dowMatch := spec.dow.matches(int(t.Weekday()))
+	if !spec.dom.any && !spec.dow.any {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // the DST cases need zone data on every machine
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Sunday, October 18 2026
+	from := time.Date(2026, 10, 18, 10, 7, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", from, at(10, 18, 10, 8)},
+		{"exact minute is excluded", "* * * * *", at(10, 18, 10, 8), at(10, 18, 10, 9)},
+		{"step", "*/15 * * * *", from, at(10, 18, 10, 15)},
+		{"step from a start", "10/20 * * * *", from, at(10, 18, 10, 10)},
+		{"step from a start wraps the hour", "10/20 * * * *", at(10, 18, 10, 51), at(10, 18, 11, 10)},
+		{"range with step", "5-20/5 * * * *", at(10, 18, 10, 16), at(10, 18, 10, 20)},
+		{"range with step wraps the hour", "5-20/5 * * * *", at(10, 18, 10, 21), at(10, 18, 11, 5)},
+		{"list", "0 6,18 * * *", from, at(10, 18, 18, 0)},
+		{"hour range", "30 9-17 * * *", at(10, 18, 17, 45), at(10, 19, 9, 30)},
+		{"weekdays", "0 9 * * 1-5", at(10, 23, 10, 0), at(10, 26, 9, 0)},
+		{"seven is Sunday", "0 8 * * 7", at(10, 19, 0, 0), at(10, 25, 8, 0)},
+		{"day of month only", "0 9 1 * *", from, at(11, 1, 9, 0)},
+		{"day of month or weekday", "0 9 1 * 1", from, at(10, 19, 9, 0)},
+		{"weekday or day of month", "0 9 1 * 1", at(10, 27, 0, 0), at(11, 1, 9, 0)},
+		{"day of month and any weekday", "0 9 15 * *", from, at(11, 15, 9, 0)},
+		{"month", "0 0 1 1 *", from, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"month range", "0 12 * 3-5 *", from, time.Date(2027, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"31st skips short months", "0 0 31 * *", at(10, 31, 1, 0), time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{"never within five years", "0 0 30 2 *", from, time.Time{}},
+		{"never on a 31st in a short month", "0 0 31 4,6,9,11 *", from, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := spec.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+	edt := time.FixedZone("EDT", -4*60*60)
+	est := time.FixedZone("EST", -5*60*60)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// Clocks spring forward from 2:00 EST to 3:00 EDT on March 8 2026
+		{"skipped time waits a day", "30 2 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, est), time.Date(2026, 3, 9, 2, 30, 0, 0, edt)},
+		{"hourly across spring forward", "0 * * * *", time.Date(2026, 3, 8, 1, 0, 0, 0, est), time.Date(2026, 3, 8, 3, 0, 0, 0, edt)},
+		{"daily after spring forward", "0 7 * * *", time.Date(2026, 3, 7, 7, 0, 0, 0, est), time.Date(2026, 3, 8, 7, 0, 0, 0, edt)},
+
+		// Clocks fall back from 2:00 EDT to 1:00 EST on November 1 2026
+		{"repeated time runs first", "30 1 * * *", time.Date(2026, 11, 1, 0, 0, 0, 0, edt), time.Date(2026, 11, 1, 1, 30, 0, 0, edt)},
+		{"repeated time runs once", "30 1 * * *", time.Date(2026, 11, 1, 1, 30, 0, 0, edt), time.Date(2026, 11, 2, 1, 30, 0, 0, est)},
+		{"hourly across fall back", "0 * * * *", time.Date(2026, 11, 1, 1, 0, 0, 0, edt), time.Date(2026, 11, 1, 2, 0, 0, 0, est)},
+		{"daily after fall back", "0 7 * * *", time.Date(2026, 10, 31, 7, 0, 0, 0, edt), time.Date(2026, 11, 1, 7, 0, 0, 0, est)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := spec.Next(tt.from.In(ny))
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from.In(ny), got, tt.want.In(ny))
+			}
+			if got.Location() != ny {
+				t.Errorf("got location %s, want %s", got.Location(), ny)
+			}
+		})
+	}
+}
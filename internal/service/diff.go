@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+)
+
+// Fields a GroupChange can describe.
+const (
+	ChangeOn         = "on"
+	ChangeBrightness = "brightness"
+	ChangeReachable  = "reachable"
+	ChangeAdded      = "added"
+	ChangeRemoved    = "removed"
+)
+
+// GroupChange is a single difference between two states of a group.
+type GroupChange struct {
+	Group string `json:"group"`
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+// String describes the change in a short sentence such as "Porch turned on".
+func (c GroupChange) String() string {
+	switch c.Field {
+	case ChangeOn:
+		if c.New == true {
+			return fmt.Sprintf("%s turned on", c.Group)
+		}
+		return fmt.Sprintf("%s turned off", c.Group)
+	case ChangeBrightness:
+		return fmt.Sprintf("%s brightness changed from %v%% to %v%%", c.Group, c.Old, c.New)
+	case ChangeReachable:
+		if c.New == true {
+			return fmt.Sprintf("%s is reachable again", c.Group)
+		}
+		return fmt.Sprintf("%s became unreachable", c.Group)
+	case ChangeAdded:
+		return fmt.Sprintf("%s was added", c.Group)
+	case ChangeRemoved:
+		return fmt.Sprintf("%s was removed", c.Group)
+	default:
+		return fmt.Sprintf("%s %s changed", c.Group, c.Field)
+	}
+}
+
+// DiffGroups returns the on/off, brightness and reachability changes between the old
+// and new states of each group, plus groups that were added or removed.
+func DiffGroups(old, new Groups) []GroupChange {
+	previous := make(map[string]Group, len(old))
+	for _, g := range old {
+		previous[g.Name] = g
+	}
+
+	var changes []GroupChange
+	for _, g := range new {
+		p, ok := previous[g.Name]
+		if !ok {
+			changes = append(changes, GroupChange{Group: g.Name, Field: ChangeAdded})
+			continue
+		}
+		delete(previous, g.Name)
+
+		was, is := stateOf(p), stateOf(g)
+		if was.On != is.On {
+			changes = append(changes, GroupChange{Group: g.Name, Field: ChangeOn, Old: was.On, New: is.On})
+		}
+		// Brightness only matters while the lights are on
+		if is.On && was.On && was.Bri != is.Bri {
+			changes = append(changes, GroupChange{
+				Group: g.Name,
+				Field: ChangeBrightness,
+				Old:   brightnessPercent(was.Bri),
+				New:   brightnessPercent(is.Bri),
+			})
+		}
+		// Reachability is only known when the client reported the group's lights
+		if p.Reachable != nil && g.Reachable != nil && *p.Reachable != *g.Reachable {
+			changes = append(changes, GroupChange{Group: g.Name, Field: ChangeReachable, Old: *p.Reachable, New: *g.Reachable})
+		}
+	}
+
+	for _, g := range old {
+		if _, ok := previous[g.Name]; ok {
+			changes = append(changes, GroupChange{Group: g.Name, Field: ChangeRemoved})
+		}
+	}
+	return changes
+}
+
+// groupState is the part of a group's light state DiffGroups compares.
+type groupState struct {
+	On  bool
+	Bri uint8
+}
+
+func stateOf(g Group) groupState {
+	if g.State == nil {
+		return groupState{}
+	}
+	return groupState{On: g.State.On, Bri: g.State.Bri}
+}
+
+func brightnessPercent(bri uint8) int {
+	return int(float64(bri)/MaxBrightness*100 + 0.5)
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/amimof/huego"
+)
+
+// testGroup returns a group with the given light state and reachability, nil when unknown.
+func testGroup(name string, on bool, bri uint8, reachable *bool) Group {
+	return Group{
+		Group:     huego.Group{Name: name, State: &huego.State{On: on, Bri: bri}},
+		Reachable: reachable,
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestDiffGroups(t *testing.T) {
+	tests := []struct {
+		name string
+		old  Groups
+		new  Groups
+		want []GroupChange
+	}{
+		{
+			name: "unchanged",
+			old:  Groups{testGroup("Porch", true, 254, boolPtr(true))},
+			new:  Groups{testGroup("Porch", true, 254, boolPtr(true))},
+		},
+		{
+			name: "turned on",
+			old:  Groups{testGroup("Porch", false, 0, nil)},
+			new:  Groups{testGroup("Porch", true, 254, nil)},
+			want: []GroupChange{{Group: "Porch", Field: ChangeOn, Old: false, New: true}},
+		},
+		{
+			name: "brightness",
+			old:  Groups{testGroup("Porch", true, 127, nil)},
+			new:  Groups{testGroup("Porch", true, 254, nil)},
+			want: []GroupChange{{Group: "Porch", Field: ChangeBrightness, Old: 50, New: 100}},
+		},
+		{
+			name: "brightness while off",
+			old:  Groups{testGroup("Porch", false, 127, nil)},
+			new:  Groups{testGroup("Porch", false, 254, nil)},
+		},
+		{
+			name: "became unreachable",
+			old:  Groups{testGroup("Porch", true, 254, boolPtr(true))},
+			new:  Groups{testGroup("Porch", true, 254, boolPtr(false))},
+			want: []GroupChange{{Group: "Porch", Field: ChangeReachable, Old: true, New: false}},
+		},
+		{
+			name: "reachable again",
+			old:  Groups{testGroup("Porch", true, 254, boolPtr(false))},
+			new:  Groups{testGroup("Porch", true, 254, boolPtr(true))},
+			want: []GroupChange{{Group: "Porch", Field: ChangeReachable, Old: false, New: true}},
+		},
+		{
+			name: "reachability unknown",
+			old:  Groups{testGroup("Porch", true, 254, boolPtr(true))},
+			new:  Groups{testGroup("Porch", true, 254, nil)},
+		},
+		{
+			name: "added and removed",
+			old:  Groups{testGroup("Porch", true, 254, nil), testGroup("Den", true, 254, nil)},
+			new:  Groups{testGroup("Porch", true, 254, nil), testGroup("Kitchen", true, 254, nil)},
+			want: []GroupChange{{Group: "Kitchen", Field: ChangeAdded}, {Group: "Den", Field: ChangeRemoved}},
+		},
+		{
+			name: "no state",
+			old:  Groups{{Group: huego.Group{Name: "Porch"}}},
+			new:  Groups{testGroup("Porch", true, 254, nil)},
+			want: []GroupChange{{Group: "Porch", Field: ChangeOn, Old: false, New: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffGroups(tt.old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupChangeString(t *testing.T) {
+	tests := []struct {
+		change GroupChange
+		want   string
+	}{
+		{GroupChange{Group: "Porch", Field: ChangeOn, Old: false, New: true}, "Porch turned on"},
+		{GroupChange{Group: "Porch", Field: ChangeOn, Old: true, New: false}, "Porch turned off"},
+		{GroupChange{Group: "Porch", Field: ChangeBrightness, Old: 50, New: 100}, "Porch brightness changed from 50% to 100%"},
+		{GroupChange{Group: "Porch", Field: ChangeReachable, Old: true, New: false}, "Porch became unreachable"},
+		{GroupChange{Group: "Porch", Field: ChangeReachable, Old: false, New: true}, "Porch is reachable again"},
+		{GroupChange{Group: "Porch", Field: ChangeAdded}, "Porch was added"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.change.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestGroupsSetReachable(t *testing.T) {
+	groups := Groups{
+		{Group: huego.Group{Name: "Porch", Lights: []string{"1", "2"}}},
+		{Group: huego.Group{Name: "Kitchen", Lights: []string{"3"}}},
+		{Group: huego.Group{Name: "Den", Lights: []string{"4"}}},
+		{Group: huego.Group{Name: "Hall", Lights: []string{"5", "6"}}},
+	}
+	lights := map[string]huego.Light{
+		"1": {State: &huego.State{Reachable: true}},
+		"2": {State: &huego.State{Reachable: false}},
+		"3": {State: &huego.State{Reachable: true}},
+		"5": {State: &huego.State{Reachable: true}},
+	}
+
+	groups.SetReachable(lights)
+
+	want := map[string]*bool{
+		"Porch":   boolPtr(false), // one of its lights is unreachable
+		"Kitchen": boolPtr(true),
+		"Den":     nil, // its light wasn't reported
+		"Hall":    boolPtr(true),
+	}
+	for _, g := range groups {
+		if !reflect.DeepEqual(g.Reachable, want[g.Name]) {
+			t.Errorf("%s: got reachable %v, want %v", g.Name, g.Reachable, want[g.Name])
+		}
+	}
+
+	// A state without lights leaves every group unknown
+	groups.SetReachable(nil)
+	for _, g := range groups {
+		if g.Reachable != nil {
+			t.Errorf("%s: got reachable %v, want unknown", g.Name, *g.Reachable)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historyCacheSize is how many of the most recent history entries a FileStore keeps
+// in memory to answer History from.
+const historyCacheSize = 1000
+
+// FileStore is the default Store. It keeps client state in a JSON file that is
+// rewritten on every change and appends the history to a JSON lines file. The most
+// recent history entries are also kept in memory, so reading them never scans the
+// file.
+type FileStore struct {
+	statePath string
+
+	mu     sync.Mutex
+	states map[string]ClientState
+
+	// historyMu guards the history file and recent, so recording history never waits
+	// on a state file being rewritten.
+	historyMu sync.Mutex
+	history   *os.File
+	recent    []HistoryEntry
+}
+
+// NewFileStore opens or creates a file store in dir.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{
+		statePath: filepath.Join(dir, "clients.json"),
+		states:    make(map[string]ClientState),
+	}
+
+	data, err := os.ReadFile(fs.statePath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		var stored []ClientState
+		err = json.Unmarshal(data, &stored)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", fs.statePath, err)
+		}
+		for _, st := range stored {
+			fs.states[st.ClientID] = st
+		}
+	}
+
+	fs.history, err = os.OpenFile(filepath.Join(dir, "history.jsonl"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.loadRecent()
+	if err != nil {
+		fs.history.Close()
+		return nil, fmt.Errorf("reading the history: %w", err)
+	}
+	return fs, nil
+}
+
+// loadRecent fills recent with the last entries of the history file. Lines that
+// can't be decoded are skipped.
+func (fs *FileStore) loadRecent() error {
+	scanner := bufio.NewScanner(fs.history)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		fs.addRecent(entry)
+	}
+	return scanner.Err()
+}
+
+// addRecent keeps entry in memory, dropping the oldest entry once historyCacheSize
+// are kept. The caller must hold historyMu, or be opening the store.
+func (fs *FileStore) addRecent(entry HistoryEntry) {
+	fs.recent = append(fs.recent, entry)
+	if len(fs.recent) > historyCacheSize {
+		fs.recent = fs.recent[len(fs.recent)-historyCacheSize:]
+	}
+}
+
+// SaveClientState replaces the stored state of the client.
+func (fs *FileStore) SaveClientState(state ClientState) error {
+	if state.UpdatedAt.IsZero() {
+		state.UpdatedAt = time.Now()
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.states[state.ClientID] = state
+	return writeJSONFile(fs.statePath, fs.clientStatesLocked())
+}
+
+// ClientStates returns the stored state of every client ordered by client ID.
+func (fs *FileStore) ClientStates() ([]ClientState, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.clientStatesLocked(), nil
+}
+
+func (fs *FileStore) clientStatesLocked() []ClientState {
+	states := make([]ClientState, 0, len(fs.states))
+	for _, st := range fs.states {
+		states = append(states, st)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ClientID < states[j].ClientID })
+	return states
+}
+
+// Record appends an entry to the history file.
+func (fs *FileStore) Record(entry HistoryEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	fs.historyMu.Lock()
+	defer fs.historyMu.Unlock()
+	_, err = fs.history.Write(line)
+	if err != nil {
+		return err
+	}
+	fs.addRecent(entry)
+	return nil
+}
+
+// History returns up to limit of the most recent entries, oldest first. Only the last
+// historyCacheSize entries are available.
+func (fs *FileStore) History(limit int) ([]HistoryEntry, error) {
+	fs.historyMu.Lock()
+	defer fs.historyMu.Unlock()
+
+	entries := fs.recent
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return append([]HistoryEntry(nil), entries...), nil
+}
+
+// Close closes the history file.
+func (fs *FileStore) Close() error {
+	fs.historyMu.Lock()
+	defer fs.historyMu.Unlock()
+	return fs.history.Close()
+}
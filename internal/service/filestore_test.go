@@ -0,0 +1,178 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestStore opens a FileStore in dir and closes it when the test ends.
+func openTestStore(t *testing.T, dir string) *FileStore {
+	t.Helper()
+
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func TestFileStoreHistory(t *testing.T) {
+	fs := openTestStore(t, t.TempDir())
+
+	for i := 0; i < 5; i++ {
+		if err := fs.Record(HistoryEntry{Kind: HistoryCommand, Text: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{limit: 2, want: []string{"3", "4"}},
+		{limit: 5, want: []string{"0", "1", "2", "3", "4"}},
+		{limit: 10, want: []string{"0", "1", "2", "3", "4"}},
+		{limit: 0, want: []string{"0", "1", "2", "3", "4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("limit %d", tt.limit), func(t *testing.T) {
+			history, err := fs.History(tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := historyTexts(history); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			for _, entry := range history {
+				if entry.Time.IsZero() {
+					t.Errorf("entry %q has no time", entry.Text)
+				}
+			}
+		})
+	}
+
+	// Changing the returned entries doesn't change the store
+	history, _ := fs.History(1)
+	history[0].Text = "changed"
+	if history, _ := fs.History(1); history[0].Text != "4" {
+		t.Errorf("got %q, want the stored entry unchanged", history[0].Text)
+	}
+}
+
+// historyTexts returns the text of each entry.
+func historyTexts(history []HistoryEntry) []string {
+	texts := make([]string, 0, len(history))
+	for _, entry := range history {
+		texts = append(texts, entry.Text)
+	}
+	return texts
+}
+
+func TestFileStoreHistoryReopened(t *testing.T) {
+	dir := t.TempDir()
+
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < historyCacheSize+10; i++ {
+		if err := fs.Record(HistoryEntry{Kind: HistoryCommand, Text: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A line that can't be decoded is skipped
+	f, err := os.OpenFile(filepath.Join(dir, "history.jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "{not json")
+	f.Close()
+
+	fs = openTestStore(t, dir)
+	if err := fs.Record(HistoryEntry{Kind: HistoryCommand, Text: "after"}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := fs.History(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != historyCacheSize {
+		t.Fatalf("got %d entries, want the last %d", len(history), historyCacheSize)
+	}
+	if first, last := history[0].Text, history[len(history)-1].Text; first != "11" || last != "after" {
+		t.Errorf("got entries %q to %q, want 11 to after", first, last)
+	}
+}
+
+func TestFileStoreClientStates(t *testing.T) {
+	dir := t.TempDir()
+	updated := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"home", "cabin"} {
+		if err := fs.SaveClientState(ClientState{ClientID: id, UpdatedAt: updated}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saving a client again replaces its state
+	if err := fs.SaveClientState(ClientState{ClientID: "home", Scenes: Scenes{{ID: "1", Name: "Relax"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := openTestStore(t, dir).ClientStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0].ClientID != "cabin" || states[1].ClientID != "home" {
+		t.Fatalf("got %+v, want cabin and home in order", states)
+	}
+	if !states[0].UpdatedAt.Equal(updated) {
+		t.Errorf("got cabin updated at %v, want %v", states[0].UpdatedAt, updated)
+	}
+	if len(states[1].Scenes) != 1 || states[1].UpdatedAt.IsZero() {
+		t.Errorf("got home %+v, want its replaced state with an update time", states[1])
+	}
+}
+
+func TestFileStoreRecordDuringStateSave(t *testing.T) {
+	fs := openTestStore(t, t.TempDir())
+
+	// Recording history doesn't wait on the state lock
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		if err := fs.Record(HistoryEntry{Kind: HistoryCommand}); err != nil {
+			done <- err
+			return
+		}
+		_, err := fs.History(1)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("recording history blocked while client state was being saved")
+	}
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GrammarParser understands common phrasings such as "kitchen off", "turn on the
+// bedroom", "dim the office by 10%", "set porch to 40%" and "status of kitchen"
+// without calling a language model. Group names are matched loosely, so small typos
+// still work. Anything else is left to the next parser with ErrNoIntent.
+type GrammarParser struct {
+	Intents IntentSchemas // decode the actions like those of other parsers
+}
+
+// grammarAction is an action in the same JSON format the OpenAI prompt asks for.
+type grammarAction struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+type grammarUpdate struct {
+	Group           string `json:"group"`
+	IsOn            bool   `json:"isOn"`
+	Brightness      *int   `json:"brightness,omitempty"`
+	BrightnessDelta *int   `json:"brightnessDelta,omitempty"`
+}
+
+type grammarStatus struct {
+	Room GroupNames `json:"room"`
+}
+
+var (
+	// grammarSeparatorRX splits a message into separate commands.
+	grammarSeparatorRX = regexp.MustCompile(`\s*(?:,|;|\band then\b|\bthen\b|\band\b)\s*`)
+	// grammarPercentRX matches a brightness level or change such as "40%" or "by 10 percent".
+	grammarPercentRX = regexp.MustCompile(`\b(?:by |to |at )?(\d{1,3})\s*(?:%|percent\b)`)
+	// grammarFillerWords are dropped before a command is matched.
+	grammarFillerWords = map[string]bool{
+		"please": true, "the": true, "lights": true, "light": true, "lamps": true, "in": true,
+		"can": true, "could": true, "you": true, "would": true, "hey": true, "my": true,
+	}
+	grammarAllWords = map[string]bool{"all": true, "everything": true, "house": true, "whole": true, "every": true}
+)
+
+// ParseIntent converts the message into actions, or returns ErrNoIntent when any part
+// of it doesn't follow the grammar.
+func (p GrammarParser) ParseIntent(req IntentRequest) ([]Intent, error) {
+	text := strings.ToLower(strings.TrimSpace(req.Text))
+	text = strings.Trim(text, ".!?")
+	if text == "" || len(req.Groups) == 0 {
+		return nil, ErrNoIntent
+	}
+
+	var intents []Intent
+	for _, part := range grammarSeparatorRX.Split(text, -1) {
+		action, ok := parseGrammarCommand(part, req.Groups)
+		if !ok {
+			return nil, ErrNoIntent
+		}
+
+		data, err := json.Marshal(action.Data)
+		if err != nil {
+			return nil, err
+		}
+		intent, err := p.Intents.Decode(action.Type, data)
+		if err != nil {
+			return nil, err
+		}
+		intents = append(intents, intent)
+	}
+	return intents, nil
+}
+
+// parseGrammarCommand matches a single command such as "turn kitchen off".
+func parseGrammarCommand(text string, groups Groups) (grammarAction, bool) {
+	// Pull out a percentage before splitting into words
+	percent := -1
+	if m := grammarPercentRX.FindStringSubmatch(text); m != nil {
+		percent, _ = strconv.Atoi(m[1])
+		if percent > 100 {
+			return grammarAction{}, false
+		}
+		text = strings.Replace(text, m[0], " ", 1)
+	}
+
+	text = strings.NewReplacer("'s", "", "what is", "status", "how is", "status", "how are", "status").Replace(text)
+
+	var words []string
+	for _, w := range strings.Fields(text) {
+		if !grammarFillerWords[w] {
+			words = append(words, w)
+		}
+	}
+
+	// Sort the words into the verb and the group they refer to
+	var verb string
+	var rest []string
+	small, question := false, false
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		switch w {
+		case "is", "are":
+			// A question such as "is the kitchen on" asks for the status
+			if i == 0 {
+				question = true
+			}
+			continue
+		case "turn", "switch", "set", "make", "to", "of", "at", "by":
+			continue
+		case "a":
+			if i+1 < len(words) && (words[i+1] == "bit" || words[i+1] == "little") {
+				small = true
+				i++
+			}
+			continue
+		case "bit", "little", "slightly":
+			small = true
+			continue
+		case "on", "off", "status", "dim", "brighten":
+			verb = w
+			continue
+		case "up", "brighter":
+			verb = "brighten"
+			continue
+		case "down", "dimmer", "darker":
+			verb = "dim"
+			continue
+		}
+		rest = append(rest, w)
+	}
+
+	if question {
+		verb = "status"
+	}
+	if verb == "" && percent >= 0 {
+		verb = "set"
+	}
+	if verb == "" {
+		return grammarAction{}, false
+	}
+
+	// Status may leave out the group to mean every group
+	group, ok := matchGrammarGroup(strings.Join(rest, " "), groups)
+	if !ok && !(verb == "status" && len(rest) == 0) {
+		return grammarAction{}, false
+	}
+
+	switch verb {
+	case "status":
+		names := GroupNames{group}
+		if len(rest) == 0 || group == "all" {
+			names = groups.Names()
+		}
+		return grammarAction{Type: "status", Data: grammarStatus{Room: names}}, true
+
+	case "on", "off":
+		if percent >= 0 {
+			return grammarAction{}, false
+		}
+		update := grammarUpdate{Group: group, IsOn: verb == "on"}
+		if update.IsOn {
+			full := MaxBrightness
+			update.Brightness = &full
+		}
+		return grammarAction{Type: "update", Data: update}, true
+
+	case "dim", "brighten":
+		delta := 25
+		if small {
+			delta = 10
+		}
+		if percent >= 0 {
+			delta = percent
+		}
+		if verb == "dim" {
+			delta = -delta
+		}
+		return grammarAction{Type: "update", Data: grammarUpdate{Group: group, IsOn: true, BrightnessDelta: &delta}}, true
+
+	default:
+		if percent == 0 {
+			return grammarAction{Type: "update", Data: grammarUpdate{Group: group, IsOn: false}}, true
+		}
+		bri := (percent*MaxBrightness + 50) / 100
+		return grammarAction{Type: "update", Data: grammarUpdate{Group: group, IsOn: true, Brightness: &bri}}, true
+	}
+}
+
+// matchGrammarGroup returns the name of the group the phrase refers to, or "all" for
+// phrases such as "everything". A phrase matches a group that equals it, starts with
+// it or is within a couple of typos of it, as long as only one group matches. Leftover
+// words such as "in 10 minutes" match nothing, leaving the message to OpenAI.
+func matchGrammarGroup(phrase string, groups Groups) (string, bool) {
+	if phrase == "" {
+		return "", false
+	}
+
+	words := strings.Fields(phrase)
+	allWords := true
+	for _, w := range words {
+		allWords = allWords && grammarAllWords[w]
+	}
+	if allWords {
+		return "all", true
+	}
+
+	for _, g := range groups {
+		if strings.EqualFold(g.Name, phrase) {
+			return g.Name, true
+		}
+	}
+
+	best, bestDistance, unique := "", -1, false
+	for _, g := range groups {
+		name := strings.ToLower(g.Name)
+		d := levenshtein(phrase, name)
+		if strings.HasPrefix(name, phrase) {
+			d = 0
+		}
+		if d > max(1, len(name)/4) {
+			continue
+		}
+		switch {
+		case bestDistance < 0 || d < bestDistance:
+			best, bestDistance, unique = g.Name, d, true
+		case d == bestDistance:
+			unique = false
+		}
+	}
+	return best, unique
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/amimof/huego"
+)
+
+// testGroups returns groups with the given names.
+func testGroups(names ...string) Groups {
+	groups := make(Groups, 0, len(names))
+	for _, name := range names {
+		groups = append(groups, Group{Group: huego.Group{Name: name}})
+	}
+	return groups
+}
+
+func TestGrammarParser(t *testing.T) {
+	parser := GrammarParser{Intents: testIntents()}
+	groups := testGroups("Kitchen", "Office", "Bedroom", "Living Room")
+
+	tests := []struct {
+		text string
+		want []Intent
+	}{
+		{
+			text: "kitchen off",
+			want: []Intent{{Type: "update", Data: testUpdate{Group: "Kitchen"}}},
+		},
+		{
+			text: "Please turn on the bedroom lights.",
+			want: []Intent{{Type: "update", Data: testUpdate{Group: "Bedroom", IsOn: true, Brightness: intPtr(MaxBrightness)}}},
+		},
+		{
+			text: "dim the office by 10%",
+			want: []Intent{{Type: "update", Data: testUpdate{Group: "Office", IsOn: true, BrightnessDelta: intPtr(-10)}}},
+		},
+		{
+			text: "make the living room a bit brighter",
+			want: []Intent{{Type: "update", Data: testUpdate{Group: "Living Room", IsOn: true, BrightnessDelta: intPtr(10)}}},
+		},
+		{
+			text: "set office to 50%",
+			want: []Intent{{Type: "update", Data: testUpdate{Group: "Office", IsOn: true, Brightness: intPtr(127)}}},
+		},
+		{
+			text: "kitchn off",
+			want: []Intent{{Type: "update", Data: testUpdate{Group: "Kitchen"}}},
+		},
+		{
+			text: "turn off the bedrom",
+			want: []Intent{{Type: "update", Data: testUpdate{Group: "Bedroom"}}},
+		},
+		{
+			text: "everything off",
+			want: []Intent{{Type: "update", Data: testUpdate{Group: "all"}}},
+		},
+		{
+			text: "kitchen off and office on",
+			want: []Intent{
+				{Type: "update", Data: testUpdate{Group: "Kitchen"}},
+				{Type: "update", Data: testUpdate{Group: "Office", IsOn: true, Brightness: intPtr(MaxBrightness)}},
+			},
+		},
+		{
+			text: "status of kitchen",
+			want: []Intent{{Type: "status", Data: testStatus{Room: GroupNames{"Kitchen"}}}},
+		},
+		{
+			text: "is the office on?",
+			want: []Intent{{Type: "status", Data: testStatus{Room: GroupNames{"Office"}}}},
+		},
+		{
+			text: "status",
+			want: []Intent{{Type: "status", Data: testStatus{Room: groups.Names()}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parser.ParseIntent(IntentRequest{Text: tt.text, Groups: groups})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %s, want %s", FormatIntents(got), FormatIntents(tt.want))
+			}
+		})
+	}
+}
+
+func TestGrammarParserNoIntent(t *testing.T) {
+	parser := GrammarParser{Intents: testIntents()}
+	groups := testGroups("Kitchen", "Office", "Offices", "Bedroom")
+
+	for _, text := range []string{
+		"",
+		"play some jazz",
+		"kitchen",
+		"turn off the kitchen in 10 minutes",
+		"kitchen off and play some jazz",
+		"set the kitchen to 150%",
+		"turn the garage off",
+		"offic off",
+		"make the kitchen blue",
+	} {
+		t.Run(text, func(t *testing.T) {
+			got, err := parser.ParseIntent(IntentRequest{Text: text, Groups: groups})
+			if !errors.Is(err, ErrNoIntent) {
+				t.Errorf("got %s, %v; want ErrNoIntent", FormatIntents(got), err)
+			}
+		})
+	}
+
+	t.Run("no groups", func(t *testing.T) {
+		_, err := parser.ParseIntent(IntentRequest{Text: "kitchen off"})
+		if !errors.Is(err, ErrNoIntent) {
+			t.Errorf("got %v, want ErrNoIntent", err)
+		}
+	})
+}
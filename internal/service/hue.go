@@ -2,6 +2,8 @@ package service
 
 import (
 	"fmt"
+	"math"
+	"reflect"
 	"slices"
 	"strings"
 
@@ -28,8 +30,68 @@ func (gn GroupNames) ArrayString() string {
 	return formattedString
 }
 
+// Scene is a Hue scene the client can recall for one of its groups.
+type Scene struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Group string `json:"group"`
+}
+
+type Scenes []Scene
+
+// ForGroup returns the scenes that belong to the named group.
+func (ss Scenes) ForGroup(group string) Scenes {
+	var scenes Scenes
+	for _, sc := range ss {
+		if strings.EqualFold(sc.Group, group) {
+			scenes = append(scenes, sc)
+		}
+	}
+	return scenes
+}
+
+// Lookup finds the scene of the named group with the given name, ignoring case.
+func (ss Scenes) Lookup(group, name string) (Scene, bool) {
+	for _, sc := range ss.ForGroup(group) {
+		if strings.EqualFold(sc.Name, name) {
+			return sc, true
+		}
+	}
+	return Scene{}, false
+}
+
+// Names returns the name of every scene in ss.
+func (ss Scenes) Names() []string {
+	names := make([]string, 0, len(ss))
+	for _, sc := range ss {
+		names = append(names, sc.Name)
+	}
+	return names
+}
+
+// String lists the scenes one group per line, e.g. "Living room: Relax, Movie night".
+func (ss Scenes) String() string {
+	var groups []string
+	byGroup := make(map[string][]string)
+	for _, sc := range ss {
+		if _, ok := byGroup[sc.Group]; !ok {
+			groups = append(groups, sc.Group)
+		}
+		byGroup[sc.Group] = append(byGroup[sc.Group], sc.Name)
+	}
+
+	var sb strings.Builder
+	for _, g := range groups {
+		sb.WriteString(fmt.Sprintf("%s: %s\n", g, strings.Join(byGroup[g], ", ")))
+	}
+	return sb.String()
+}
+
 type Group struct {
 	huego.Group
+	// Reachable reports whether every light in the group is reachable. It is nil when
+	// the client didn't report the state of the group's lights.
+	Reachable *bool `json:"reachable,omitempty"`
 }
 type Groups []Group
 
@@ -37,29 +99,79 @@ func (gs *Groups) GroupStatusMessage(names GroupNames) string {
 	msg := ""
 	for _, g := range *gs {
 		if slices.Contains(names, g.Name) {
-			isOn := "Off"
-			if g.State.On {
-				isOn = "On"
-				// Convert the brightness value to a percentage
-				if g.State.Bri > 0 {
-					brightnessPercentage := (float64(g.State.Bri) / 254.0) * 100
-					msg += fmt.Sprintf("%v: %v, Brightness: %.0f%%\n", g.Name, isOn, brightnessPercentage)
-				} else {
-					msg += fmt.Sprintf("%v: %v\n", g.Name, isOn)
-				}
-			} else {
-				msg += fmt.Sprintf("%v: %v\n", g.Name, isOn)
-			}
+			msg += g.StatusLine() + "\n"
 		}
 	}
 	return msg
 }
 
+// StatusLine describes the group's state in a short line such as "Kitchen: On, 75%, 2700K".
+func (g Group) StatusLine() string {
+	if g.State == nil || !g.State.On {
+		return fmt.Sprintf("%v: Off", g.Name)
+	}
+
+	line := fmt.Sprintf("%v: On", g.Name)
+	// Convert the brightness value to a percentage
+	if g.State.Bri > 0 {
+		brightnessPercentage := (float64(g.State.Bri) / 254.0) * 100
+		line += fmt.Sprintf(", %.0f%%", brightnessPercentage)
+	}
+	if color := g.colorDescription(); color != "" {
+		line += ", " + color
+	}
+	return line
+}
+
+// colorDescription describes the group's color, color temperature or effect.
+func (g Group) colorDescription() string {
+	if g.State.Effect == "colorloop" {
+		return "color loop"
+	}
+
+	switch g.State.ColorMode {
+	case "ct":
+		if g.State.Ct > 0 {
+			return fmt.Sprintf("%dK", MiredToKelvin(g.State.Ct))
+		}
+	case "xy", "hs":
+		return NearestColorName(g.State.Xy)
+	}
+	return ""
+}
+
+// MaxBrightness is the brightest value the Hue bridge accepts.
+const MaxBrightness = 254
+
+// BrightnessAfter returns the absolute brightness that results from changing the group's
+// current brightness by deltaPercent of full brightness, clamped to 0-MaxBrightness.
+// A group that is off counts as brightness 0.
+func (g Group) BrightnessAfter(deltaPercent int) int {
+	current := 0
+	if g.State != nil && g.State.On {
+		current = int(g.State.Bri)
+	}
+
+	delta := int(math.Round(float64(deltaPercent) / 100 * MaxBrightness))
+	return min(max(current+delta, 0), MaxBrightness)
+}
+
 func (g Group) String() string {
-	return fmt.Sprintf(
-		`{"group": %s, "isOn": %v, "brightness": %d}`,
-		g.Name, g.State.On, g.State.Bri,
-	)
+	if g.State == nil {
+		return fmt.Sprintf(`{"group": %s}`, g.Name)
+	}
+
+	s := fmt.Sprintf(`{"group": %s, "isOn": %v, "brightness": %d`, g.Name, g.State.On, g.State.Bri)
+	switch g.State.ColorMode {
+	case "ct":
+		s += fmt.Sprintf(`, "colorTemp": %d`, MiredToKelvin(g.State.Ct))
+	case "xy", "hs":
+		s += fmt.Sprintf(`, "color": %s`, NearestColorName(g.State.Xy))
+	}
+	if g.State.Effect != "" && g.State.Effect != "none" {
+		s += fmt.Sprintf(`, "effect": %s`, g.State.Effect)
+	}
+	return s + "}"
 }
 
 func (gs Groups) String() string {
@@ -69,3 +181,47 @@ func (gs Groups) String() string {
 	}
 	return sb.String()
 }
+
+// SetReachable sets the reachability of every group from the state of its lights,
+// keyed by light ID as the bridge lists them. A group is reachable while all of its
+// reported lights are; groups none of whose lights were reported stay unknown.
+func (gs Groups) SetReachable(lights map[string]huego.Light) {
+	for i := range gs {
+		gs[i].Reachable = nil
+		for _, id := range gs[i].Lights {
+			light, ok := lights[id]
+			if !ok || light.State == nil {
+				continue
+			}
+			reachable := light.State.Reachable && (gs[i].Reachable == nil || *gs[i].Reachable)
+			gs[i].Reachable = &reachable
+		}
+	}
+}
+
+// Changed returns the groups in gs that are new or whose state differs from the group
+// with the same name in old.
+func (gs Groups) Changed(old Groups) Groups {
+	previous := make(map[string]Group, len(old))
+	for _, g := range old {
+		previous[g.Name] = g
+	}
+
+	var changed Groups
+	for _, g := range gs {
+		p, ok := previous[g.Name]
+		if !ok || !reflect.DeepEqual(p.State, g.State) || !reflect.DeepEqual(p.Reachable, g.Reachable) {
+			changed = append(changed, g)
+		}
+	}
+	return changed
+}
+
+// Names returns the name of every group in gs.
+func (gs Groups) Names() GroupNames {
+	names := make(GroupNames, 0, len(gs))
+	for _, g := range gs {
+		names = append(names, g.Name)
+	}
+	return names
+}
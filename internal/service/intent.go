@@ -0,0 +1,84 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNoIntent is returned by an IntentParser that doesn't understand the message.
+var ErrNoIntent = errors.New("message not understood")
+
+// IntentRequest is a message to understand along with what the sender may control.
+type IntentRequest struct {
+	Text   string
+	Groups Groups
+	Scenes Scenes
+	Now    time.Time
+	// History holds the sender's earlier turns for resolving follow-ups.
+	History []Turn
+
+	// Previous and Problem are set when retrying: the actions parsed on the last attempt
+	// and why they were rejected, so the parser can correct them.
+	Previous string
+	Problem  string
+}
+
+// Intent is an action a message asks for. Data holds the action's arguments decoded
+// into the Go type of its IntentSchema, or nil for actions that take none.
+type Intent struct {
+	Type string `json:"type"`
+	Data any    `json:"data,omitempty"`
+}
+
+// FormatIntents returns intents in the JSON format the OpenAI prompt asks for: a
+// single {"type": ..., "data": ...} object or an array of them.
+func FormatIntents(intents []Intent) string {
+	var out []byte
+	if len(intents) == 1 {
+		out, _ = json.Marshal(intents[0])
+	} else {
+		out, _ = json.Marshal(intents)
+	}
+	return string(out)
+}
+
+// InvalidIntentError is returned by an IntentParser whose response doesn't decode
+// into known intents. The response and the problem can be sent back to correct it.
+type InvalidIntentError struct {
+	Response string
+	Err      error
+}
+
+func (e *InvalidIntentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidIntentError) Unwrap() error {
+	return e.Err
+}
+
+// IntentParser converts a natural-language message into the actions the server runs.
+type IntentParser interface {
+	ParseIntent(req IntentRequest) ([]Intent, error)
+}
+
+// IntentParsers tries each parser in order and returns the first result. Ordering
+// the local grammar before or after OpenAI makes it a fast path or a fallback.
+type IntentParsers []IntentParser
+
+// ParseIntent returns the actions of the first parser that understands the message.
+// When every parser fails it returns the first error other than ErrNoIntent.
+func (ps IntentParsers) ParseIntent(req IntentRequest) ([]Intent, error) {
+	err := ErrNoIntent
+	for _, p := range ps {
+		actions, perr := p.ParseIntent(req)
+		if perr == nil {
+			return actions, nil
+		}
+		if errors.Is(err, ErrNoIntent) {
+			err = perr
+		}
+	}
+	return nil, err
+}
@@ -1,9 +1,14 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"time"
 
 	openai "github.com/sashabaranov/go-openai"
 )
@@ -11,17 +16,193 @@ import (
 type OpenaiService struct {
 	Client            *openai.Client
 	SystemRoleMessage *string
+	Model             string        // defaults to GPT-4o
+	Temperature       *float32      // nil for the server default
+	MaxTokens         int           // 0 for the server default
+	Timeout           time.Duration // 0 for no timeout
+	// Intents decode the model's response. They are offered to the model as functions
+	// so it answers with typed function calls instead of free text.
+	Intents IntentSchemas
 }
 
-func SystemRoleMessage(groups Groups, groupNames GroupNames) string {
+// toolsInstruction replaces the request for a bare JSON response when the intents are
+// offered as functions.
+const toolsInstruction = `
+Respond by calling one function per action, in the order they should run. The function name is the action type and its arguments are the action's data.`
+
+// chatRequest returns a chat completion request for messages with the configured
+// model and parameters.
+func (s *OpenaiService) chatRequest(messages []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+	req := openai.ChatCompletionRequest{
+		Model:     s.Model,
+		Messages:  messages,
+		MaxTokens: s.MaxTokens,
+	}
+	if req.Model == "" {
+		req.Model = openai.GPT4o
+	}
+	if s.Temperature != nil {
+		// The client omits a zero temperature, so ask for the smallest one instead
+		req.Temperature = max(*s.Temperature, math.SmallestNonzeroFloat32)
+	}
+	return req
+}
+
+// context returns the context of a request to OpenAI, bounded by the timeout.
+func (s *OpenaiService) context() (context.Context, context.CancelFunc) {
+	if s.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.Timeout)
+}
+
+// historyInstruction explains the earlier turns included with a message.
+const historyInstruction = `
+Earlier messages from the same person and the JSON they were converted into come before the latest message. Use them to understand follow-ups such as "a bit brighter" or "the bedroom too", but only return the actions for the latest message.`
+
+// ParseIntent asks OpenAI for the actions the message describes. On a retry the
+// rejected actions and the problem with them are added to the conversation. A
+// response that doesn't decode is returned as an InvalidIntentError.
+func (s *OpenaiService) ParseIntent(req IntentRequest) ([]Intent, error) {
+	systemRoleMessage := SystemRoleMessage(req.Groups, req.Groups.Names(), req.Scenes, req.Now)
+	if len(s.Intents) > 0 {
+		systemRoleMessage += toolsInstruction
+	}
+
+	if len(req.History) > 0 {
+		systemRoleMessage += historyInstruction
+	}
+
+	// Earlier turns come before the message as user texts and the actions they became
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: systemRoleMessage,
+		},
+	}
+	for _, turn := range req.History {
+		messages = append(messages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: turn.Text},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: turn.Actions})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: req.Text,
+	})
+	if req.Problem != "" {
+		messages = append(messages,
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: req.Previous,
+			},
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: fmt.Sprintf("That response can't be used: %s\nPlease correct it, using only the groups, scenes and values listed above.", req.Problem),
+			})
+	}
+
+	response, err := s.respond(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	intents, err := s.Intents.Parse(response)
+	if err != nil {
+		return nil, &InvalidIntentError{Response: response, Err: err}
+	}
+	return intents, nil
+}
+
+// respond returns the model's response to messages as JSON actions, calling the
+// intents as functions when there are any.
+func (s *OpenaiService) respond(messages []openai.ChatCompletionMessage) (string, error) {
+	if len(s.Intents) == 0 {
+		return s.complete(messages)
+	}
+
+	tools := make([]openai.Tool, 0, len(s.Intents))
+	for _, intent := range s.Intents {
+		tools = append(tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        intent.Name,
+				Description: intent.Description,
+				Parameters:  intent.Parameters(),
+			},
+		})
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	chatRequest := s.chatRequest(messages)
+	chatRequest.Tools = tools
+	chatRequest.ToolChoice = "required"
+	resp, err := s.Client.CreateChatCompletion(ctx, chatRequest)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("openai returned no choices")
+	}
+
+	// Servers that ignore the functions still answer with the JSON in the content
+	msg := resp.Choices[0].Message
+	if len(msg.ToolCalls) == 0 {
+		return CleanGPTResponse(msg.Content), nil
+	}
+	return toolCallActions(msg.ToolCalls)
+}
+
+// toolCallActions returns the function calls as actions in the same JSON format as a
+// text response, to be decoded like one.
+func toolCallActions(calls []openai.ToolCall) (string, error) {
+	type action struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+
+	actions := make([]action, 0, len(calls))
+	for _, call := range calls {
+		args := json.RawMessage(call.Function.Arguments)
+		if len(bytes.TrimSpace(args)) == 0 {
+			args = json.RawMessage("{}")
+		}
+		if !json.Valid(args) {
+			return "", fmt.Errorf("openai called %s with arguments that aren't JSON", call.Function.Name)
+		}
+		actions = append(actions, action{Type: call.Function.Name, Data: args})
+	}
+
+	var out []byte
+	var err error
+	if len(actions) == 1 {
+		out, err = json.Marshal(actions[0])
+	} else {
+		out, err = json.Marshal(actions)
+	}
+	return string(out), err
+}
+
+func SystemRoleMessage(groups Groups, groupNames GroupNames, scenes Scenes, now time.Time) string {
 	message := fmt.Sprintf(`
 Given the following action options separated by new lines you are to convert natural language text about Hue light groups into JSON.
 '''
 status
 update
+scene
+schedule
+list_schedules
+cancel_schedule
+create_rule
+list_rules
+pause_rule
+resume_rule
+delete_rule
+clarify
 '''
 
-Requests should refer to one of the following groups or all groups:
+Requests should refer to one of the following groups or all groups. Use the group "all" for update requests about every group, everything or the whole house:
 '''
 %v
 '''
@@ -35,11 +216,35 @@ Here is information on each of the groups that you may use to help create meanin
 
 %v
 
+%v
+
+%v
+
+%v
+
+%v
+
+%v
+
 Your response should just be the JSON string not wrapped in any other text.
-`, groupNames.String(), fmt.Sprintf("%+v\n", groups), statusExamples(groupNames), updateExamples(groups))
+`, groupNames.String(), fmt.Sprintf("%+v\n", groups), statusExamples(groupNames), updateExamples(groups), sceneExamples(scenes), multiActionExamples(groups), scheduleExamples(groups, now), ruleExamples(groups), clarifyExamples(groups))
 	return message
 }
 
+func clarifyExamples(groups Groups) string {
+	second := groups[len(groups)-1].Name
+	example := fmt.Sprintf(`
+If a request could mean more than one group or scene, or doesn't clearly map to any, don't guess. Respond with a clarify request holding a short question and the possible answers:
+    request:
+    "Turn off the lights upstairs"
+    response:
+    {"type": "clarify", "data": {"question": "Which group did you mean?", "options": ["%v", "%v"]}}
+
+If the request ends with your question and an answer in parentheses, such as "Turn off the lights upstairs (Which group did you mean? %v)", use the answer to complete the original request.
+`, groups[0].Name, second, groups[0].Name)
+	return example
+}
+
 func statusExamples(groupNames GroupNames) string {
 	example := fmt.Sprintf(`
 Here is an example of a status request and the expected JSON you should respond with:
@@ -61,8 +266,13 @@ func updateExamples(groups Groups) string {
 Here is an example of an update request to turn groups on or off and the expected JSON you should respond with:
   NOTES: 
   - brightness is optional and should be set to 254 if not provided.
-  - if "isOn" is false do not include brightness
-  - if asked to turn up or down brightness do so on increments of 25% with 0 being off and 254 being full brightness.
+  - if "isOn" is false do not include brightness, colorTemp, color or effect
+  - "brightness" is an absolute value with 0 being off and 254 being full brightness.
+  - if asked to turn brightness up or down without a target level, use "brightnessDelta" instead of "brightness". It is a change in percent of full brightness between -100 and 100; use 25 or -25 by default and 10 or -10 for "a bit" or "a little".
+  - "colorTemp" is a color temperature in kelvin between %d and %d. Warm white is 2700, neutral white is 4000 and cool white or daylight is 6500.
+  - "color" must be one of: %v
+  - "effect" must be one of: %v
+  - only include colorTemp, color or effect when the request asks for them, and never include both colorTemp and color.
     request:
     "Please turn %v on."
     response:
@@ -74,33 +284,184 @@ Here is an example of an update request to turn groups on or off and the expecte
     {"type": "update", "data": {"group": "%v", "isOn": false}}
     
     request:
-    -Note: act as if current brightness is 254
-    "Please turn down brightness of kitchen"
-    "{"type": "update", "data": {"group": "kitchen", "isOn": true, "brightness": 191}}"
+    "Please turn down brightness of %v"
+    response:
+    {"type": "update", "data": {"group": "%v", "isOn": true, "brightnessDelta": -25}}
+
+    request:
+    "Make %v a little brighter"
+    response:
+    {"type": "update", "data": {"group": "%v", "isOn": true, "brightnessDelta": 10}}
+
+    request:
+    "Turn everything off"
+    response:
+    {"type": "update", "data": {"group": "all", "isOn": false}}
+
+    request:
+    "Make %v warm white"
+    response:
+    {"type": "update", "data": {"group": "%v", "isOn": true, "colorTemp": 2700}}
+
+    request:
+    "Set %v to blue at 40%%"
+    response:
+    {"type": "update", "data": {"group": "%v", "isOn": true, "color": "blue", "brightness": 102}}
+
+    request:
+    "Make %v cycle through colors"
+    response:
+    {"type": "update", "data": {"group": "%v", "isOn": true, "effect": "colorloop"}}
     
-    `, strings.ToLower(groups[0].Name), groups[0].Name, strings.ToLower(groups[0].Name), groups[0].Name)
+    `, MinColorTempKelvin, MaxColorTempKelvin, strings.Join(ColorNames(), ", "), strings.Join(Effects, ", "),
+		strings.ToLower(groups[0].Name), groups[0].Name, strings.ToLower(groups[0].Name), groups[0].Name,
+		strings.ToLower(groups[0].Name), groups[0].Name, strings.ToLower(groups[0].Name), groups[0].Name,
+		strings.ToLower(groups[0].Name), groups[0].Name, strings.ToLower(groups[0].Name), groups[0].Name,
+		strings.ToLower(groups[0].Name), groups[0].Name)
+	return example
+}
+
+func sceneExamples(scenes Scenes) string {
+	if len(scenes) == 0 {
+		return `
+No scenes are available, so never respond with a scene request.
+`
+	}
+
+	example := fmt.Sprintf(`
+Here are the scenes available for each group, one group per line:
+'''
+%v'''
+
+Here is an example of a request to activate a scene and the expected JSON you should respond with:
+  NOTES:
+  - "scene" must be one of the scenes listed for the group.
+    request:
+    "Set %v to %v"
+    response:
+    {"type": "scene", "data": {"group": "%v", "scene": "%v"}}
+`, scenes.String(), strings.ToLower(scenes[0].Group), strings.ToLower(scenes[0].Name), scenes[0].Group, scenes[0].Name)
+	return example
+}
+
+func multiActionExamples(groups Groups) string {
+	last := groups[len(groups)-1].Name
+	example := fmt.Sprintf(`
+If a request asks for more than one action, respond with a JSON array holding one object per action in the order they were asked for. Actions of different types may be mixed.
+    request:
+    "Turn off %v and dim %v to 30%%, then tell me the status of %v"
+    response:
+    [{"type": "update", "data": {"group": "%v", "isOn": false}}, {"type": "update", "data": {"group": "%v", "isOn": true, "brightness": 76}}, {"type": "status", "data": {"room": ["%v"]}}]
+`, strings.ToLower(groups[0].Name), strings.ToLower(last), strings.ToLower(last), groups[0].Name, last, last)
+	return example
+}
+
+func scheduleExamples(groups Groups, now time.Time) string {
+	at := time.Date(now.Year(), now.Month(), now.Day(), 23, 0, 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+
+	example := fmt.Sprintf(`
+The current time is %v.
+
+Here is an example of requests to run update or scene actions later and the expected JSON you should respond with:
+  NOTES:
+  - use "in" with a duration such as "20m" or "1h30m" for delays, or "at" with an RFC 3339 time in the current time's offset for clock times.
+  - "description" is a short summary of the actions.
+  - "actions" holds the update or scene actions to run, written exactly as they would be if they ran now.
+    request:
+    "Turn off %v in 20 minutes"
+    response:
+    {"type": "schedule", "data": {"in": "20m", "description": "Turn off %v", "actions": [{"type": "update", "data": {"group": "%v", "isOn": false}}]}}
+
+    request:
+    "Turn off %v at 11pm"
+    response:
+    {"type": "schedule", "data": {"at": "%v", "description": "Turn off %v", "actions": [{"type": "update", "data": {"group": "%v", "isOn": false}}]}}
+
+    request:
+    "What is scheduled?"
+    response:
+    {"type": "list_schedules", "data": null}
+
+    request:
+    "Cancel 3"
+    response:
+    {"type": "cancel_schedule", "data": {"id": "3"}}
+`, now.Format(time.RFC3339), strings.ToLower(groups[0].Name), groups[0].Name, groups[0].Name,
+		strings.ToLower(groups[0].Name), at.Format(time.RFC3339), groups[0].Name, groups[0].Name)
+	return example
+}
+
+func ruleExamples(groups Groups) string {
+	example := fmt.Sprintf(`
+Here is an example of requests to manage rules that repeat and the expected JSON you should respond with:
+  NOTES:
+  - use "cron" with a five field cron expression (minute hour day-of-month month day-of-week) for clock times.
+  - use "sun" with "sunrise" or "sunset" for times relative to the sun, "offset" with a duration such as "30m" or "-15m", and optionally "days" with a cron day-of-week field.
+  - "actions" holds the update or scene actions to run, written exactly as they would be if they ran now.
+  - use schedule instead of create_rule for requests that should only happen once.
+    request:
+    "Every weekday at 6:30 turn %v on at 20%%"
+    response:
+    {"type": "create_rule", "data": {"cron": "30 6 * * 1-5", "description": "%v on at 20%%", "actions": [{"type": "update", "data": {"group": "%v", "isOn": true, "brightness": 51}}]}}
+
+    request:
+    "30 minutes after sunset turn on %v"
+    response:
+    {"type": "create_rule", "data": {"sun": "sunset", "offset": "30m", "description": "%v on after sunset", "actions": [{"type": "update", "data": {"group": "%v", "isOn": true, "brightness": 254}}]}}
+
+    request:
+    "What rules are there?"
+    response:
+    {"type": "list_rules", "data": null}
+
+    request:
+    "Pause rule 2"
+    response:
+    {"type": "pause_rule", "data": {"id": "2"}}
+
+    request:
+    "Resume rule 2"
+    response:
+    {"type": "resume_rule", "data": {"id": "2"}}
+
+    request:
+    "Delete rule 2"
+    response:
+    {"type": "delete_rule", "data": {"id": "2"}}
+`, strings.ToLower(groups[0].Name), groups[0].Name, groups[0].Name,
+		strings.ToLower(groups[0].Name), groups[0].Name, groups[0].Name)
 	return example
 }
 
 func (s *OpenaiService) TranformTextBodyToJSON(systemRoleMessage, userMessage string) (string, error) {
-	resp, err := s.Client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: openai.GPT4o,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: systemRoleMessage,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: userMessage,
-				},
-			},
-		})
+	return s.complete([]openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: systemRoleMessage,
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: userMessage,
+		},
+	})
+}
+
+// complete asks OpenAI for a plain text completion of messages with any code fences
+// removed.
+func (s *OpenaiService) complete(messages []openai.ChatCompletionMessage) (string, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	resp, err := s.Client.CreateChatCompletion(ctx, s.chatRequest(messages))
 	if err != nil {
 		return "", err
 	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("openai returned no choices")
+	}
 
 	completion := CleanGPTResponse(resp.Choices[0].Message.Content)
 
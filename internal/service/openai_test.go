@@ -0,0 +1,209 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// stubCompletion returns a chat completion response holding the message.
+func stubCompletion(msg openai.ChatCompletionMessage) openai.ChatCompletionResponse {
+	return openai.ChatCompletionResponse{
+		ID:      "chatcmpl-test",
+		Object:  "chat.completion",
+		Model:   openai.GPT4o,
+		Choices: []openai.ChatCompletionChoice{{Index: 0, Message: msg}},
+	}
+}
+
+// toolCalls returns an assistant message calling each function with its arguments,
+// given as name and arguments pairs.
+func toolCalls(pairs ...string) openai.ChatCompletionMessage {
+	msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{
+			ID:       "call_" + pairs[i],
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: pairs[i], Arguments: pairs[i+1]},
+		})
+	}
+	return msg
+}
+
+// newStubOpenai returns an OpenaiService that sends its requests to handler.
+func newStubOpenai(t *testing.T, handler http.HandlerFunc) *OpenaiService {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	cfg := openai.DefaultConfig("test-key")
+	cfg.BaseURL = srv.URL + "/v1"
+	return &OpenaiService{
+		Client:  openai.NewClientWithConfig(cfg),
+		Intents: testIntents(),
+	}
+}
+
+// respondWith returns a handler that answers every chat completion with msg.
+func respondWith(t *testing.T, msg openai.ChatCompletionMessage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("request to %s, want /v1/chat/completions", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stubCompletion(msg))
+	}
+}
+
+func testIntentRequest(text string) IntentRequest {
+	return IntentRequest{
+		Text:   text,
+		Groups: testGroups("Kitchen", "Office"),
+		Now:    time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestOpenaiParseIntent(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  openai.ChatCompletionMessage
+		want []Intent
+	}{
+		{
+			name: "single call",
+			msg:  toolCalls("update", `{"group": "Kitchen", "isOn": false}`),
+			want: []Intent{{Type: "update", Data: testUpdate{Group: "Kitchen"}}},
+		},
+		{
+			name: "multiple calls",
+			msg: toolCalls(
+				"update", `{"group": "Kitchen", "isOn": false}`,
+				"update", `{"group": "Office", "isOn": true, "brightness": 254}`,
+				"list_schedules", ``,
+			),
+			want: []Intent{
+				{Type: "update", Data: testUpdate{Group: "Kitchen"}},
+				{Type: "update", Data: testUpdate{Group: "Office", IsOn: true, Brightness: intPtr(254)}},
+				{Type: "list_schedules"},
+			},
+		},
+		{
+			name: "plain content",
+			msg: openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: "```json\n{\"type\": \"status\", \"data\": {\"room\": [\"Office\"]}}\n```",
+			},
+			want: []Intent{{Type: "status", Data: testStatus{Room: GroupNames{"Office"}}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStubOpenai(t, respondWith(t, tt.msg))
+
+			got, err := s.ParseIntent(testIntentRequest("lights"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenaiParseIntentInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     openai.ChatCompletionMessage
+		wantErr string
+	}{
+		{
+			name:    "unknown function",
+			msg:     toolCalls("dance", `{"style": "disco"}`),
+			wantErr: `unknown action type "dance"`,
+		},
+		{
+			name:    "extra field",
+			msg:     toolCalls("update", `{"group": "Kitchen", "isOn": false, "mood": "cozy"}`),
+			wantErr: `unknown field "mood"`,
+		},
+		{
+			name: "one bad call among good ones",
+			msg: toolCalls(
+				"update", `{"group": "Kitchen", "isOn": false}`,
+				"update", `{"group": "Office", "isOn": "off"}`,
+			),
+			wantErr: "invalid data for update",
+		},
+		{
+			name: "plain content that isn't JSON",
+			msg: openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: "I turned off the kitchen.",
+			},
+			wantErr: "invalid character",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStubOpenai(t, respondWith(t, tt.msg))
+
+			got, err := s.ParseIntent(testIntentRequest("lights"))
+			var invalid *InvalidIntentError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("got %v, %v; want an InvalidIntentError", got, err)
+			}
+			if !strings.Contains(invalid.Err.Error(), tt.wantErr) {
+				t.Errorf("got error %q, want one containing %q", invalid.Err, tt.wantErr)
+			}
+			if invalid.Response == "" {
+				t.Error("got an empty response, want the rejected one for the retry")
+			}
+		})
+	}
+}
+
+func TestOpenaiParseIntentRetry(t *testing.T) {
+	var messages []openai.ChatCompletionMessage
+	s := newStubOpenai(t, func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		messages = req.Messages
+
+		if req.ToolChoice != "required" || len(req.Tools) != len(testIntents()) {
+			t.Errorf("got tool choice %v with %d tools, want every intent required", req.ToolChoice, len(req.Tools))
+		}
+		json.NewEncoder(w).Encode(stubCompletion(toolCalls("update", `{"group": "Office", "isOn": false}`)))
+	})
+
+	req := testIntentRequest("turn off the den")
+	req.Previous = `{"type":"update","data":{"group":"Den","isOn":false}}`
+	req.Problem = "I don't know a group called 'Den'."
+	_, err := s.ParseIntent(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 4 {
+		t.Fatalf("got %d messages, want system, user, rejected answer and problem", len(messages))
+	}
+	if messages[2].Role != openai.ChatMessageRoleAssistant || messages[2].Content != req.Previous {
+		t.Errorf("got %+v, want the previous answer", messages[2])
+	}
+	if !strings.Contains(messages[3].Content, req.Problem) {
+		t.Errorf("got %q, want it to explain the problem", messages[3].Content)
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// Pending holds at most one value per sender, such as a question waiting for its
+// answer, until it is taken or expires.
+type Pending[T any] struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	items map[string]pendingItem[T]
+}
+
+type pendingItem[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// NewPending returns a store whose values expire ttl after they are put.
+func NewPending[T any](ttl time.Duration) *Pending[T] {
+	return &Pending[T]{ttl: ttl, items: make(map[string]pendingItem[T])}
+}
+
+// Put stores the sender's value, replacing any earlier one.
+func (p *Pending[T]) Put(sender string, value T, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items[sender] = pendingItem[T]{value: value, expires: now.Add(p.ttl)}
+}
+
+// Take removes and returns the sender's value. Expired values are dropped and
+// reported with expired set.
+func (p *Pending[T]) Take(sender string, now time.Time) (value T, ok, expired bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, found := p.items[sender]
+	if !found {
+		return value, false, false
+	}
+	delete(p.items, sender)
+	if now.After(item.expires) {
+		return value, false, true
+	}
+	return item.value, true, false
+}
@@ -0,0 +1,347 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRuleNotFound is returned when changing a rule that does not exist.
+var ErrRuleNotFound = errors.New("rule not found")
+
+// Rule is an action that runs repeatedly, either on a cron schedule or at an offset
+// from sunrise or sunset on the given days.
+type Rule struct {
+	ID          string          `json:"id"`
+	Description string          `json:"description"`
+	Cron        string          `json:"cron,omitempty"`
+	Sun         string          `json:"sun,omitempty"`    // "sunrise" or "sunset"
+	Offset      string          `json:"offset,omitempty"` // Go duration from the sun event, e.g. "30m" or "-15m"
+	Days        string          `json:"days,omitempty"`   // cron day of week field for sun rules, "*" when empty
+	Paused      bool            `json:"paused"`
+	Owner       string          `json:"owner,omitempty"` // phone number of the user who created it
+	Action      json.RawMessage `json:"action"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// String describes the rule in a short line such as "2: Porch on (30m after sunset)".
+func (r Rule) String() string {
+	s := fmt.Sprintf("%s: %s (%s)", r.ID, r.Description, r.when())
+	if r.Paused {
+		s += " [paused]"
+	}
+	return s
+}
+
+// when describes when the rule runs.
+func (r Rule) when() string {
+	if r.Cron != "" {
+		return "cron " + r.Cron
+	}
+
+	s := r.Sun
+	if offset, err := time.ParseDuration(r.Offset); err == nil && offset != 0 {
+		if offset < 0 {
+			s = fmt.Sprintf("%v before %s", -offset, r.Sun)
+		} else {
+			s = fmt.Sprintf("%v after %s", offset, r.Sun)
+		}
+	}
+	if r.Days != "" && r.Days != "*" {
+		s += ", days " + r.Days
+	}
+	return s
+}
+
+// Rules runs recurring rules as they come due and persists them to a JSON file.
+type Rules struct {
+	path     string
+	location Coordinates
+	run      func(Rule)
+
+	mu     sync.Mutex
+	rules  map[string]Rule
+	nextID int
+	wake   chan struct{}
+}
+
+// NewRules loads the rules stored at path. location is used for sunrise and sunset
+// rules, and run is called from the rules goroutine every time a rule comes due.
+func NewRules(path string, location Coordinates, run func(Rule)) (*Rules, error) {
+	rs := &Rules{
+		path:     path,
+		location: location,
+		run:      run,
+		rules:    make(map[string]Rule),
+		nextID:   1,
+		wake:     make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []Rule
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	for _, r := range stored {
+		rs.rules[r.ID] = r
+		if id, err := strconv.Atoi(r.ID); err == nil && id >= rs.nextID {
+			rs.nextID = id + 1
+		}
+	}
+	return rs, nil
+}
+
+// Start runs rules as they come due until stop is closed. Occurrences missed while
+// the server was down are skipped.
+func (rs *Rules) Start(stop <-chan struct{}) {
+	go func() {
+		lastCheck := time.Now()
+		for {
+			wait := time.Hour
+			if next, ok := rs.nextRun(lastCheck); ok {
+				wait = max(time.Until(next), 0)
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-rs.wake:
+				timer.Stop()
+			case <-timer.C:
+				now := time.Now()
+				for _, r := range rs.due(lastCheck, now) {
+					rs.run(r)
+				}
+				lastCheck = now
+			}
+		}
+	}()
+}
+
+// Add validates and stores a new rule.
+func (rs *Rules) Add(r Rule) (Rule, error) {
+	if _, err := rs.next(r, time.Now()); err != nil {
+		return Rule{}, err
+	}
+
+	rs.mu.Lock()
+	r.ID = strconv.Itoa(rs.nextID)
+	r.CreatedAt = time.Now()
+	rs.nextID++
+	rs.rules[r.ID] = r
+	err := rs.saveLocked()
+	rs.mu.Unlock()
+
+	if err != nil {
+		return Rule{}, err
+	}
+	rs.notify()
+	return r, nil
+}
+
+// SetPaused pauses or resumes the rule with the given ID.
+func (rs *Rules) SetPaused(id string, paused bool) (Rule, error) {
+	rs.mu.Lock()
+	r, ok := rs.rules[id]
+	if !ok {
+		rs.mu.Unlock()
+		return Rule{}, ErrRuleNotFound
+	}
+	r.Paused = paused
+	rs.rules[id] = r
+	err := rs.saveLocked()
+	rs.mu.Unlock()
+
+	if err != nil {
+		return Rule{}, err
+	}
+	rs.notify()
+	return r, nil
+}
+
+// Delete removes the rule with the given ID.
+func (rs *Rules) Delete(id string) (Rule, error) {
+	rs.mu.Lock()
+	r, ok := rs.rules[id]
+	if !ok {
+		rs.mu.Unlock()
+		return Rule{}, ErrRuleNotFound
+	}
+	delete(rs.rules, id)
+	err := rs.saveLocked()
+	rs.mu.Unlock()
+
+	if err != nil {
+		return Rule{}, err
+	}
+	rs.notify()
+	return r, nil
+}
+
+// Get returns the rule with the given ID.
+func (rs *Rules) Get(id string) (Rule, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	r, ok := rs.rules[id]
+	return r, ok
+}
+
+// List returns every rule ordered by ID.
+func (rs *Rules) List() []Rule {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	list := make([]Rule, 0, len(rs.rules))
+	for _, r := range rs.rules {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := strconv.Atoi(list[i].ID)
+		b, _ := strconv.Atoi(list[j].ID)
+		return a < b
+	})
+	return list
+}
+
+// ListMessage describes the rules keep accepts one per line. A nil keep describes
+// them all.
+func (rs *Rules) ListMessage(keep func(Rule) bool) string {
+	var lines []string
+	for _, r := range rs.List() {
+		if keep == nil || keep(r) {
+			lines = append(lines, r.String())
+		}
+	}
+	if len(lines) == 0 {
+		return "There are no rules."
+	}
+	return strings.Join(lines, "\n")
+}
+
+// next returns the next time the rule runs after t.
+func (rs *Rules) next(r Rule, t time.Time) (time.Time, error) {
+	if r.Cron != "" {
+		if r.Sun != "" {
+			return time.Time{}, errors.New("a rule can't use both cron and sun")
+		}
+		spec, err := ParseCron(r.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		next := spec.Next(t)
+		if next.IsZero() {
+			return time.Time{}, fmt.Errorf("cron expression %q never matches", r.Cron)
+		}
+		return next, nil
+	}
+
+	if r.Sun != "sunrise" && r.Sun != "sunset" {
+		return time.Time{}, fmt.Errorf("a rule needs a cron expression or a sun event of sunrise or sunset")
+	}
+	if !rs.location.IsSet() {
+		return time.Time{}, errors.New("sunrise and sunset rules need the server's latitude and longitude")
+	}
+
+	var offset time.Duration
+	if r.Offset != "" {
+		var err error
+		offset, err = time.ParseDuration(r.Offset)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid offset %q: %w", r.Offset, err)
+		}
+	}
+
+	days := r.Days
+	if days == "" {
+		days = "*"
+	}
+	dow, err := parseWeekdays(days)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Start a day early since a negative offset can move an event onto the previous day.
+	day := t.AddDate(0, 0, -1)
+	for i := 0; i < 370; i++ {
+		event, ok := rs.location.Sunset(day)
+		if r.Sun == "sunrise" {
+			event, ok = rs.location.Sunrise(day)
+		}
+		if ok && dow.matches(int(day.Weekday())) {
+			if at := event.Add(offset); at.After(t) {
+				return at, nil
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}, fmt.Errorf("the sun never reaches %s at this location", r.Sun)
+}
+
+// nextRun returns the soonest time any active rule runs after t.
+func (rs *Rules) nextRun(t time.Time) (time.Time, bool) {
+	var soonest time.Time
+	for _, r := range rs.List() {
+		if r.Paused {
+			continue
+		}
+		next, err := rs.next(r, t)
+		if err != nil {
+			continue
+		}
+		if soonest.IsZero() || next.Before(soonest) {
+			soonest = next
+		}
+	}
+	return soonest, !soonest.IsZero()
+}
+
+// due returns the active rules that came due after from and at or before to.
+func (rs *Rules) due(from, to time.Time) []Rule {
+	var due []Rule
+	for _, r := range rs.List() {
+		if r.Paused {
+			continue
+		}
+		next, err := rs.next(r, from)
+		if err == nil && !next.After(to) {
+			due = append(due, r)
+		}
+	}
+	return due
+}
+
+// notify wakes the rules goroutine so it recomputes its timer.
+func (rs *Rules) notify() {
+	select {
+	case rs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// saveLocked writes the rules to disk. The caller must hold rs.mu.
+func (rs *Rules) saveLocked() error {
+	list := make([]Rule, 0, len(rs.rules))
+	for _, r := range rs.rules {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return writeJSONFile(rs.path, list)
+}
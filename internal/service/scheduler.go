@@ -0,0 +1,226 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrScheduleNotFound is returned when cancelling a schedule that does not exist.
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule is an action that runs once at a set time.
+type Schedule struct {
+	ID          string          `json:"id"`
+	RunAt       time.Time       `json:"runAt"`
+	Description string          `json:"description"`
+	Owner       string          `json:"owner,omitempty"` // phone number of the user who created it
+	Action      json.RawMessage `json:"action"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// String describes the schedule in a short line such as "3: Turn off porch at Oct 18 11:00PM".
+func (s Schedule) String() string {
+	return fmt.Sprintf("%s: %s at %s", s.ID, s.Description, s.RunAt.Local().Format("Jan 2 3:04PM"))
+}
+
+// Scheduler runs one-shot actions when they come due and persists pending ones to a
+// JSON file so they survive restarts.
+type Scheduler struct {
+	path string
+	run  func(Schedule)
+
+	mu        sync.Mutex
+	schedules map[string]Schedule
+	nextID    int
+	wake      chan struct{}
+}
+
+// NewScheduler loads the pending schedules stored at path. run is called from the
+// scheduler's goroutine for every schedule that comes due.
+func NewScheduler(path string, run func(Schedule)) (*Scheduler, error) {
+	s := &Scheduler{
+		path:      path,
+		run:       run,
+		schedules: make(map[string]Schedule),
+		nextID:    1,
+		wake:      make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []Schedule
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	for _, sc := range stored {
+		s.schedules[sc.ID] = sc
+		if id, err := strconv.Atoi(sc.ID); err == nil && id >= s.nextID {
+			s.nextID = id + 1
+		}
+	}
+	return s, nil
+}
+
+// Start runs due schedules until stop is closed. Schedules that came due while the
+// server was down run as soon as it starts.
+func (s *Scheduler) Start(stop <-chan struct{}) {
+	go func() {
+		for {
+			timer := time.NewTimer(s.untilNext())
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-s.wake:
+				timer.Stop()
+			case <-timer.C:
+				for _, sc := range s.takeDue(time.Now()) {
+					s.run(sc)
+				}
+			}
+		}
+	}()
+}
+
+// Add schedules action to run at runAt on behalf of owner.
+func (s *Scheduler) Add(runAt time.Time, description, owner string, action json.RawMessage) (Schedule, error) {
+	s.mu.Lock()
+	sc := Schedule{
+		ID:          strconv.Itoa(s.nextID),
+		RunAt:       runAt,
+		Description: description,
+		Owner:       owner,
+		Action:      action,
+		CreatedAt:   time.Now(),
+	}
+	s.nextID++
+	s.schedules[sc.ID] = sc
+	err := s.saveLocked()
+	s.mu.Unlock()
+
+	if err != nil {
+		return Schedule{}, err
+	}
+	s.notify()
+	return sc, nil
+}
+
+// Cancel removes the pending schedule with the given ID.
+func (s *Scheduler) Cancel(id string) (Schedule, error) {
+	s.mu.Lock()
+	sc, ok := s.schedules[id]
+	if !ok {
+		s.mu.Unlock()
+		return Schedule{}, ErrScheduleNotFound
+	}
+	delete(s.schedules, id)
+	err := s.saveLocked()
+	s.mu.Unlock()
+
+	if err != nil {
+		return Schedule{}, err
+	}
+	s.notify()
+	return sc, nil
+}
+
+// List returns the pending schedules, soonest first.
+func (s *Scheduler) List() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Schedule, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		list = append(list, sc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RunAt.Before(list[j].RunAt) })
+	return list
+}
+
+// Get returns the pending schedule with the given ID.
+func (s *Scheduler) Get(id string) (Schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.schedules[id]
+	return sc, ok
+}
+
+// ListMessage describes the pending schedules keep accepts one per line. A nil keep
+// describes them all.
+func (s *Scheduler) ListMessage(keep func(Schedule) bool) string {
+	var lines []string
+	for _, sc := range s.List() {
+		if keep == nil || keep(sc) {
+			lines = append(lines, sc.String())
+		}
+	}
+	if len(lines) == 0 {
+		return "Nothing is scheduled."
+	}
+	return strings.Join(lines, "\n")
+}
+
+// untilNext returns how long to wait for the soonest schedule.
+func (s *Scheduler) untilNext() time.Duration {
+	list := s.List()
+	if len(list) == 0 {
+		return time.Hour
+	}
+	return max(time.Until(list[0].RunAt), 0)
+}
+
+// takeDue removes and returns the schedules due at now.
+func (s *Scheduler) takeDue(now time.Time) []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Schedule
+	for id, sc := range s.schedules {
+		if !sc.RunAt.After(now) {
+			due = append(due, sc)
+			delete(s.schedules, id)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].RunAt.Before(due[j].RunAt) })
+	// A failed save only means the schedules may run again after a restart.
+	_ = s.saveLocked()
+	return due
+}
+
+// notify wakes the scheduler goroutine so it recomputes its timer.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// saveLocked writes the pending schedules to disk. The caller must hold s.mu.
+func (s *Scheduler) saveLocked() error {
+	list := make([]Schedule, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		list = append(list, sc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return writeJSONFile(s.path, list)
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// IntentSchema describes an action type OpenAI may return. Its arguments are
+// described by, and strictly decoded into, the Go type of Data.
+type IntentSchema struct {
+	Name        string
+	Description string
+	Data        any // zero value of the action's data type, or nil for none
+}
+
+// Parameters returns the JSON schema of the intent's data.
+func (is IntentSchema) Parameters() map[string]any {
+	if is.Data == nil {
+		return map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	return JSONSchema(reflect.TypeOf(is.Data))
+}
+
+// Decode strictly decodes data into a new value of the intent's data type, rejecting
+// unknown fields and values of the wrong type. Intents without data decode to nil.
+func (is IntentSchema) Decode(data []byte) (any, error) {
+	if is.Data == nil {
+		return nil, nil
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte("{}")
+	}
+
+	v := reflect.New(reflect.TypeOf(is.Data))
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// IntentSchemas are the intents a parser may return.
+type IntentSchemas []IntentSchema
+
+// Decode strictly decodes the data of the named intent.
+func (ss IntentSchemas) Decode(name string, data []byte) (Intent, error) {
+	for _, is := range ss {
+		if is.Name == name {
+			v, err := is.Decode(data)
+			if err != nil {
+				return Intent{}, fmt.Errorf("invalid data for %s: %w", name, err)
+			}
+			return Intent{Type: name, Data: v}, nil
+		}
+	}
+	return Intent{}, fmt.Errorf("unknown action type %q", name)
+}
+
+// Parse decodes a response holding either a single {"type": ..., "data": ...} object
+// or an array of them to run in order.
+func (ss IntentSchemas) Parse(response string) ([]Intent, error) {
+	type action struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+
+	response = strings.TrimSpace(response)
+	var actions []action
+	if strings.HasPrefix(response, "[") {
+		err := json.Unmarshal([]byte(response), &actions)
+		if err != nil {
+			return nil, err
+		}
+		if len(actions) == 0 {
+			return nil, errors.New("response contains no actions")
+		}
+	} else {
+		var a action
+		err := json.Unmarshal([]byte(response), &a)
+		if err != nil {
+			return nil, err
+		}
+		actions = []action{a}
+	}
+
+	intents := make([]Intent, 0, len(actions))
+	for _, a := range actions {
+		intent, err := ss.Decode(a.Type, a.Data)
+		if err != nil {
+			return nil, err
+		}
+		intents = append(intents, intent)
+	}
+	return intents, nil
+}
+
+var intentType = reflect.TypeOf(Intent{})
+
+// JSONSchema derives a JSON schema from a Go type, following its json tags. Fields
+// without omitempty are required. An Intent is described as a nested action.
+func JSONSchema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == intentType:
+		return map[string]any{
+			"type":        "object",
+			"description": `An action object with "type" and "data", as in the examples.`,
+		}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]any{"type": "array", "items": JSONSchema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": JSONSchema(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+
+			properties[name] = JSONSchema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	default:
+		return map[string]any{}
+	}
+}
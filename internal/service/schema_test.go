@@ -0,0 +1,184 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testUpdate struct {
+	Group           string `json:"group"`
+	IsOn            bool   `json:"isOn"`
+	Brightness      *int   `json:"brightness,omitempty"`
+	BrightnessDelta *int   `json:"brightnessDelta,omitempty"`
+}
+
+type testStatus struct {
+	Room GroupNames `json:"room"`
+}
+
+type testSchedule struct {
+	In      string   `json:"in"`
+	Actions []Intent `json:"actions"`
+}
+
+// testIntents returns intents shaped like the ones the server offers.
+func testIntents() IntentSchemas {
+	return IntentSchemas{
+		{Name: "status", Description: "Report the state of groups.", Data: testStatus{}},
+		{Name: "update", Description: "Change a group.", Data: testUpdate{}},
+		{Name: "schedule", Description: "Run actions later.", Data: testSchedule{}},
+		{Name: "list_schedules", Description: "List the pending schedules."},
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestIntentSchemaDecode(t *testing.T) {
+	update := IntentSchema{Name: "update", Data: testUpdate{}}
+
+	tests := []struct {
+		name    string
+		schema  IntentSchema
+		data    string
+		want    any
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			schema: update,
+			data:   `{"group": "Kitchen", "isOn": true, "brightness": 254}`,
+			want:   testUpdate{Group: "Kitchen", IsOn: true, Brightness: intPtr(254)},
+		},
+		{
+			name:    "extra field",
+			schema:  update,
+			data:    `{"group": "Kitchen", "isOn": false, "color": "red"}`,
+			wantErr: `unknown field "color"`,
+		},
+		{
+			name:    "wrong type",
+			schema:  update,
+			data:    `{"group": "Kitchen", "isOn": "yes"}`,
+			wantErr: "cannot unmarshal string",
+		},
+		{
+			name:   "empty arguments",
+			schema: update,
+			data:   ``,
+			want:   testUpdate{},
+		},
+		{
+			name:   "no data",
+			schema: IntentSchema{Name: "list_schedules"},
+			data:   `{}`,
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.schema.Decode([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntentSchemasParse(t *testing.T) {
+	intents := testIntents()
+
+	tests := []struct {
+		name     string
+		response string
+		want     []Intent
+		wantErr  string
+	}{
+		{
+			name:     "single action",
+			response: `{"type": "update", "data": {"group": "Kitchen", "isOn": false}}`,
+			want:     []Intent{{Type: "update", Data: testUpdate{Group: "Kitchen"}}},
+		},
+		{
+			name: "array of actions",
+			response: `[{"type": "update", "data": {"group": "Kitchen", "isOn": false}},
+				{"type": "list_schedules"}]`,
+			want: []Intent{
+				{Type: "update", Data: testUpdate{Group: "Kitchen"}},
+				{Type: "list_schedules"},
+			},
+		},
+		{
+			name:     "unknown type",
+			response: `{"type": "dance", "data": {}}`,
+			wantErr:  `unknown action type "dance"`,
+		},
+		{
+			name:     "extra field",
+			response: `{"type": "status", "data": {"room": ["Kitchen"], "verbose": true}}`,
+			wantErr:  `invalid data for status`,
+		},
+		{
+			name:     "no actions",
+			response: `[]`,
+			wantErr:  "no actions",
+		},
+		{
+			name:     "not JSON",
+			response: `Sure! The kitchen is off.`,
+			wantErr:  "invalid character",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := intents.Parse(tt.response)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	got := JSONSchema(reflect.TypeOf(testSchedule{}))
+
+	if got["type"] != "object" || got["additionalProperties"] != false {
+		t.Errorf("got %v, want a closed object", got)
+	}
+	if required := got["required"].([]string); !reflect.DeepEqual(required, []string{"in", "actions"}) {
+		t.Errorf("got required %v, want [in actions]", required)
+	}
+
+	actions := got["properties"].(map[string]any)["actions"].(map[string]any)
+	items := actions["items"].(map[string]any)
+	if actions["type"] != "array" || items["type"] != "object" || items["description"] == nil {
+		t.Errorf("got actions %v, want an array of nested actions", actions)
+	}
+
+	update := JSONSchema(reflect.TypeOf(testUpdate{}))
+	if required := update["required"].([]string); !reflect.DeepEqual(required, []string{"group", "isOn"}) {
+		t.Errorf("got required %v, want [group isOn]", required)
+	}
+}
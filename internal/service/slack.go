@@ -0,0 +1,114 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// slackPostMessageURL is the Slack Web API method used to send notifications.
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// slackMaxRequestAge is how old a signed Slack request may be before it is rejected
+// as a possible replay.
+const slackMaxRequestAge = 5 * time.Minute
+
+// slackResponse is the part of a Slack Web API response the server reads. Failed
+// calls still respond 200 OK, with ok false and the reason in error.
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// SlackCommand is a slash command Slack posted to the server.
+type SlackCommand struct {
+	UserID      string
+	Text        string
+	ResponseURL string // where the reply is posted, for up to 30 minutes
+}
+
+// SlackTransport runs slash commands and sends notifications as direct messages
+// from a Slack app.
+type SlackTransport struct {
+	SigningSecret string
+	BotToken      string // needed for notifications only
+	Client        *http.Client
+}
+
+// Name returns TransportSlack.
+func (t *SlackTransport) Name() string {
+	return TransportSlack
+}
+
+// Notify sends text as a direct message to the Slack user or channel with the ID.
+func (t *SlackTransport) Notify(channel, text string) error {
+	if t.BotToken == "" {
+		return errors.New("no Slack bot token is configured")
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+t.BotToken)
+
+	var res slackResponse
+	err := postJSON(t.Client, slackPostMessageURL, header, map[string]string{"channel": channel, "text": text}, &res)
+	if err != nil {
+		return err
+	}
+	if !res.OK {
+		return fmt.Errorf("chat.postMessage failed: %s", res.Error)
+	}
+	return nil
+}
+
+// Reply posts text back to the channel a slash command came from.
+func (t *SlackTransport) Reply(cmd SlackCommand, text string) error {
+	return postJSON(t.Client, cmd.ResponseURL, nil, map[string]string{"response_type": "ephemeral", "text": text}, nil)
+}
+
+// ParseCommand verifies the request signature and returns the slash command in body.
+func (t *SlackTransport) ParseCommand(r *http.Request, body []byte) (SlackCommand, error) {
+	err := t.verify(r, body)
+	if err != nil {
+		return SlackCommand{}, err
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return SlackCommand{}, err
+	}
+	return SlackCommand{
+		UserID:      form.Get("user_id"),
+		Text:        form.Get("text"),
+		ResponseURL: form.Get("response_url"),
+	}, nil
+}
+
+// verify checks the X-Slack-Signature header, an HMAC of the timestamp and body
+// keyed with the signing secret.
+func (t *SlackTransport) verify(r *http.Request, body []byte) error {
+	if t.SigningSecret == "" {
+		return errors.New("no Slack signing secret is configured")
+	}
+
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid Slack request timestamp %q", timestamp)
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > slackMaxRequestAge || age < -slackMaxRequestAge {
+		return errors.New("stale Slack request")
+	}
+
+	mac := hmac.New(sha256.New, []byte(t.SigningSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature"))) {
+		return errors.New("invalid Slack request signature")
+	}
+	return nil
+}
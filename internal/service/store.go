@@ -0,0 +1,48 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// History entry kinds recorded in the store.
+const (
+	HistoryInboundMessage = "inbound_message"
+	HistoryIntent         = "intent"
+	HistoryCommand        = "command"
+	HistoryResult         = "result"
+	HistoryStateChange    = "state_change"
+)
+
+// ClientState is the last known state of a home client's bridge.
+type ClientState struct {
+	ClientID  string    `json:"clientId"`
+	Groups    Groups    `json:"groups"`
+	Scenes    Scenes    `json:"scenes,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// HistoryEntry is a single record in the audit history.
+type HistoryEntry struct {
+	Time     time.Time       `json:"time"`
+	Kind     string          `json:"kind"`
+	Sender   string          `json:"sender,omitempty"`
+	ClientID string          `json:"clientId,omitempty"`
+	Text     string          `json:"text,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// Store persists client state and the audit history so the server can start warm
+// after a restart.
+type Store interface {
+	// SaveClientState replaces the stored state of the client.
+	SaveClientState(state ClientState) error
+	// ClientStates returns the stored state of every client.
+	ClientStates() ([]ClientState, error)
+	// Record appends an entry to the audit history.
+	Record(entry HistoryEntry) error
+	// History returns up to limit of the most recent entries, oldest first.
+	History(limit int) ([]HistoryEntry, error)
+	// Close releases the resources held by the store.
+	Close() error
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"math"
+	"time"
+)
+
+// Coordinates is a location on Earth in decimal degrees, north and east positive.
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// IsSet reports whether the coordinates were configured.
+func (c Coordinates) IsSet() bool {
+	return c.Latitude != 0 || c.Longitude != 0
+}
+
+// officialZenith is the sun's zenith angle at sunrise and sunset, accounting for
+// atmospheric refraction and the size of the sun's disc.
+const officialZenith = 90.833
+
+// Sunrise returns the time of sunrise at the coordinates on the calendar day of date,
+// in date's location. ok is false when the sun does not rise that day.
+func (c Coordinates) Sunrise(date time.Time) (time.Time, bool) {
+	return c.sunEvent(date, true)
+}
+
+// Sunset returns the time of sunset at the coordinates on the calendar day of date,
+// in date's location. ok is false when the sun does not set that day.
+func (c Coordinates) Sunset(date time.Time) (time.Time, bool) {
+	return c.sunEvent(date, false)
+}
+
+// sunEvent implements the sunrise equation from the Almanac for Computers, which is
+// accurate to within a minute or two.
+func (c Coordinates) sunEvent(date time.Time, rising bool) (time.Time, bool) {
+	rad := math.Pi / 180
+	lngHour := c.Longitude / 15
+
+	// Approximate time of the event as a fractional day of the year
+	dayOfYear := float64(date.YearDay())
+	approx := dayOfYear + (18-lngHour)/24
+	if rising {
+		approx = dayOfYear + (6-lngHour)/24
+	}
+
+	// Sun's mean anomaly and true longitude
+	meanAnomaly := 0.9856*approx - 3.289
+	trueLong := normalizeDegrees(meanAnomaly + 1.916*math.Sin(meanAnomaly*rad) + 0.020*math.Sin(2*meanAnomaly*rad) + 282.634)
+
+	// Right ascension, in the same quadrant as the true longitude, in hours
+	rightAsc := normalizeDegrees(math.Atan(0.91764*math.Tan(trueLong*rad)) / rad)
+	rightAsc += math.Floor(trueLong/90)*90 - math.Floor(rightAsc/90)*90
+	rightAsc /= 15
+
+	// Sun's declination and local hour angle
+	sinDec := 0.39782 * math.Sin(trueLong*rad)
+	cosDec := math.Cos(math.Asin(sinDec))
+	cosHour := (math.Cos(officialZenith*rad) - sinDec*math.Sin(c.Latitude*rad)) / (cosDec * math.Cos(c.Latitude*rad))
+	if cosHour > 1 || cosHour < -1 {
+		return time.Time{}, false
+	}
+
+	hourAngle := math.Acos(cosHour) / rad
+	if rising {
+		hourAngle = 360 - hourAngle
+	}
+	hourAngle /= 15
+
+	// Local mean time of the event, converted to UTC
+	localMean := hourAngle + rightAsc - 0.06571*approx - 6.622
+	utcHours := math.Mod(localMean-lngHour+48, 24)
+
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	event := midnight.Add(time.Duration(utcHours * float64(time.Hour))).In(date.Location())
+
+	// The UTC day can differ from the local one, so move the event onto date's day.
+	y, m, d := date.Date()
+	local := time.Date(y, m, d, 0, 0, 0, 0, date.Location())
+	switch {
+	case event.Before(local):
+		event = event.Add(24 * time.Hour)
+	case !event.Before(local.AddDate(0, 0, 1)):
+		event = event.Add(-24 * time.Hour)
+	}
+	return event.Round(time.Minute), true
+}
+
+func normalizeDegrees(deg float64) float64 {
+	deg = math.Mod(deg, 360)
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunriseSunset(t *testing.T) {
+	sanFrancisco := Coordinates{Latitude: 37.7749, Longitude: -122.4194}
+	london := Coordinates{Latitude: 51.5074, Longitude: -0.1278}
+	sydney := Coordinates{Latitude: -33.8688, Longitude: 151.2093}
+
+	la := mustLoadLocation(t, "America/Los_Angeles")
+	uk := mustLoadLocation(t, "Europe/London")
+	nsw := mustLoadLocation(t, "Australia/Sydney")
+
+	// Published times, which the almanac algorithm matches within a couple of minutes
+	tests := []struct {
+		name    string
+		at      Coordinates
+		date    time.Time
+		sunrise string
+		sunset  string
+	}{
+		{"San Francisco summer solstice", sanFrancisco, time.Date(2024, 6, 20, 12, 0, 0, 0, la), "05:48", "20:34"},
+		{"San Francisco winter solstice", sanFrancisco, time.Date(2024, 12, 21, 12, 0, 0, 0, la), "07:21", "16:54"},
+		{"London equinox", london, time.Date(2024, 3, 20, 12, 0, 0, 0, uk), "06:02", "18:14"},
+		{"Sydney summer", sydney, time.Date(2024, 12, 21, 12, 0, 0, 0, nsw), "05:41", "20:05"},
+	}
+
+	const tolerance = 3 * time.Minute
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, event := range []struct {
+				name string
+				fn   func(time.Time) (time.Time, bool)
+				want string
+			}{
+				{"sunrise", tt.at.Sunrise, tt.sunrise},
+				{"sunset", tt.at.Sunset, tt.sunset},
+			} {
+				clock, err := time.Parse("15:04", event.want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				y, m, d := tt.date.Date()
+				want := time.Date(y, m, d, clock.Hour(), clock.Minute(), 0, 0, tt.date.Location())
+
+				got, ok := event.fn(tt.date)
+				if !ok {
+					t.Fatalf("no %s, want %s", event.name, want)
+				}
+				if diff := got.Sub(want).Abs(); diff > tolerance {
+					t.Errorf("%s at %s, want %s", event.name, got, want)
+				}
+				if got.Location() != tt.date.Location() {
+					t.Errorf("%s in %s, want %s", event.name, got.Location(), tt.date.Location())
+				}
+			}
+		})
+	}
+}
+
+func TestSunriseSunsetPolar(t *testing.T) {
+	tromso := Coordinates{Latitude: 69.6492, Longitude: 18.9553}
+	oslo := mustLoadLocation(t, "Europe/Oslo")
+
+	tests := []struct {
+		name string
+		date time.Time
+	}{
+		{"midnight sun", time.Date(2024, 6, 21, 12, 0, 0, 0, oslo)},
+		{"polar night", time.Date(2024, 12, 21, 12, 0, 0, 0, oslo)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, ok := tromso.Sunrise(tt.date); ok {
+				t.Errorf("sunrise at %s, want none", got)
+			}
+			if got, ok := tromso.Sunset(tt.date); ok {
+				t.Errorf("sunset at %s, want none", got)
+			}
+		})
+	}
+
+	// The sun rises again in Tromsø by mid-February
+	if _, ok := tromso.Sunrise(time.Date(2024, 2, 15, 12, 0, 0, 0, oslo)); !ok {
+		t.Error("no sunrise in mid-February, want one")
+	}
+}
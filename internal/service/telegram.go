@@ -0,0 +1,71 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// telegramAPI is the base URL of the Telegram Bot API.
+const telegramAPI = "https://api.telegram.org"
+
+// TelegramUpdate is the part of a Telegram Bot API update the server reads.
+type TelegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		From struct {
+			ID int64 `json:"id"`
+		} `json:"from"`
+		Chat struct {
+			ID   int64  `json:"id"`
+			Type string `json:"type"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// TelegramTransport sends messages through a Telegram bot and reads the updates
+// Telegram posts to the bot's webhook.
+type TelegramTransport struct {
+	Token string
+	// Secret is the secret_token the webhook was registered with. Telegram sends it
+	// back in the X-Telegram-Bot-Api-Secret-Token header of every update.
+	Secret string
+	Client *http.Client
+}
+
+// Name returns TransportTelegram.
+func (t *TelegramTransport) Name() string {
+	return TransportTelegram
+}
+
+// Notify sends text to the chat with the given ID.
+func (t *TelegramTransport) Notify(chatID, text string) error {
+	body := map[string]string{"chat_id": chatID, "text": text}
+	return postJSON(t.Client, telegramAPI+"/bot"+t.Token+"/sendMessage", nil, body, nil)
+}
+
+// ParseUpdate checks the webhook secret and returns the sender and text of a private
+// chat message. ok is false for updates that aren't private text messages, which the
+// server ignores.
+func (t *TelegramTransport) ParseUpdate(r *http.Request, body []byte) (from, text string, ok bool, err error) {
+	secret := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if t.Secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(t.Secret)) != 1 {
+		return "", "", false, errors.New("invalid Telegram webhook secret")
+	}
+
+	var update TelegramUpdate
+	err = json.Unmarshal(body, &update)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	// Only private chats are accepted so every message can be tied to one user
+	msg := update.Message
+	if msg == nil || msg.Text == "" || msg.Chat.Type != "private" {
+		return "", "", false, nil
+	}
+	return strconv.FormatInt(msg.Chat.ID, 10), msg.Text, true, nil
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport names. Users are addressed as "<transport>:<id>", such as
+// "telegram:123456789", except Twilio users who are addressed by their bare phone
+// number.
+const (
+	TransportTwilio   = "twilio"
+	TransportWebhook  = "webhook"
+	TransportTelegram = "telegram"
+	TransportSlack    = "slack"
+	TransportFake     = "fake"
+)
+
+// Notifier sends a text message to a recipient.
+type Notifier interface {
+	// Notify sends text to the recipient identified by id within the transport.
+	Notify(id, text string) error
+}
+
+// Transport is a messaging channel the intent pipeline can be driven from.
+type Transport interface {
+	Notifier
+	// Name returns the transport name used as the prefix of its addresses.
+	Name() string
+}
+
+// Address joins a transport name and a recipient ID into a user address.
+func Address(transport, id string) string {
+	if transport == TransportTwilio {
+		return id
+	}
+	return transport + ":" + id
+}
+
+// SplitAddress splits a user address into its transport name and recipient ID.
+// Addresses without a transport prefix are Twilio phone numbers.
+func SplitAddress(addr string) (transport, id string) {
+	transport, id, ok := strings.Cut(addr, ":")
+	if !ok {
+		return TransportTwilio, addr
+	}
+	return transport, id
+}
+
+// httpTransportTimeout bounds the requests the HTTP based transports make.
+const httpTransportTimeout = 10 * time.Second
+
+// postJSON posts body encoded as JSON to url with the given extra headers and fails
+// on any non-2xx response. A successful response is decoded into result unless it is nil.
+func postJSON(client *http.Client, url string, header http.Header, body, result any) error {
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if client == nil {
+		client = &http.Client{Timeout: httpTransportTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s responded %s: %s", req.URL.Host, res.Status, bytes.TrimSpace(msg))
+	}
+
+	if result != nil {
+		err = json.NewDecoder(res.Body).Decode(result)
+		if err != nil {
+			return fmt.Errorf("reading the response of %s: %w", req.URL.Host, err)
+		}
+	}
+	return nil
+}
+
+// SentMessage is a message recorded by the FakeTransport.
+type SentMessage struct {
+	To   string
+	Text string
+}
+
+// FakeTransport records the messages sent through it instead of delivering them. It
+// is meant for tests and local development.
+type FakeTransport struct {
+	mu   sync.Mutex
+	sent []SentMessage
+}
+
+// Name returns TransportFake.
+func (f *FakeTransport) Name() string {
+	return TransportFake
+}
+
+// Notify records the message.
+func (f *FakeTransport) Notify(id, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, SentMessage{To: id, Text: text})
+	return nil
+}
+
+// Sent returns the messages recorded so far, oldest first.
+func (f *FakeTransport) Sent() []SentMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]SentMessage(nil), f.sent...)
+}
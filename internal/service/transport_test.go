@@ -0,0 +1,343 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends every request to the test server instead of its host.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// stubAPI starts a server answering with handler and returns a client whose requests
+// all go to it, whatever their URL.
+func stubAPI(t *testing.T, handler http.HandlerFunc) *http.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: redirectTransport{target: target}}
+}
+
+func TestSplitAddress(t *testing.T) {
+	tests := []struct {
+		addr      string
+		transport string
+		id        string
+	}{
+		{"+14155550123", TransportTwilio, "+14155550123"},
+		{"telegram:123456789", TransportTelegram, "123456789"},
+		{"slack:U024BE7LH", TransportSlack, "U024BE7LH"},
+	}
+
+	for _, tt := range tests {
+		transport, id := SplitAddress(tt.addr)
+		if transport != tt.transport || id != tt.id {
+			t.Errorf("SplitAddress(%q) = %q, %q; want %q, %q", tt.addr, transport, id, tt.transport, tt.id)
+		}
+		if got := Address(transport, id); got != tt.addr {
+			t.Errorf("Address(%q, %q) = %q, want %q", transport, id, got, tt.addr)
+		}
+	}
+}
+
+func TestFakeTransport(t *testing.T) {
+	var fake FakeTransport
+	fake.Notify("alice", "Kitchen: Off")
+	fake.Notify("bob", "Porch turned on")
+
+	sent := fake.Sent()
+	want := []SentMessage{{To: "alice", Text: "Kitchen: Off"}, {To: "bob", Text: "Porch turned on"}}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("got %v, want %v", sent, want)
+	}
+
+	// The returned messages are a copy
+	sent[0].Text = "changed"
+	if fake.Sent()[0].Text != "Kitchen: Off" {
+		t.Error("changing the sent messages changed the transport's record")
+	}
+}
+
+func TestSlackNotify(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantErr  string
+	}{
+		{"sent", http.StatusOK, `{"ok":true,"channel":"U024BE7LH"}`, ""},
+		{"failed", http.StatusOK, `{"ok":false,"error":"channel_not_found"}`, "channel_not_found"},
+		{"unreadable", http.StatusOK, `<html>`, "reading the response"},
+		{"server error", http.StatusInternalServerError, `oops`, "500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/chat.postMessage" {
+					t.Errorf("request to %s, want chat.postMessage", r.URL.Path)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer xoxb-test" {
+					t.Errorf("got authorization %q, want the bot token", got)
+				}
+				var body map[string]string
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Error(err)
+				}
+				if body["channel"] != "U024BE7LH" || body["text"] != "Porch turned on" {
+					t.Errorf("got body %v, want the channel and text", body)
+				}
+
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.response)
+			})
+
+			slack := &SlackTransport{BotToken: "xoxb-test", Client: client}
+			err := slack.Notify("U024BE7LH", "Porch turned on")
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("got %v, want no error", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSlackNotifyWithoutToken(t *testing.T) {
+	slack := &SlackTransport{SigningSecret: "secret"}
+	if err := slack.Notify("U024BE7LH", "hi"); err == nil {
+		t.Error("got no error, want one without a bot token")
+	}
+}
+
+// slackRequest returns a slash command request signed with secret at the given time.
+func slackRequest(secret string, at time.Time, body string) *http.Request {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	r := httptest.NewRequest(http.MethodPost, "/slack/commands", strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestSlackParseCommand(t *testing.T) {
+	body := url.Values{
+		"user_id":      {"U024BE7LH"},
+		"text":         {"kitchen off"},
+		"response_url": {"https://hooks.slack.com/commands/1234/5678"},
+	}.Encode()
+
+	tests := []struct {
+		name    string
+		secret  string
+		request func() *http.Request
+		body    string // received, when it differs from the signed body
+		wantErr bool
+	}{
+		{
+			name:    "valid signature",
+			secret:  "signing-secret",
+			request: func() *http.Request { return slackRequest("signing-secret", time.Now(), body) },
+		},
+		{
+			name:    "wrong secret",
+			secret:  "signing-secret",
+			request: func() *http.Request { return slackRequest("other-secret", time.Now(), body) },
+			wantErr: true,
+		},
+		{
+			name:    "tampered body",
+			secret:  "signing-secret",
+			request: func() *http.Request { return slackRequest("signing-secret", time.Now(), body) },
+			body:    strings.Replace(body, "off", "on", 1),
+			wantErr: true,
+		},
+		{
+			name:    "stale request",
+			secret:  "signing-secret",
+			request: func() *http.Request { return slackRequest("signing-secret", time.Now().Add(-10*time.Minute), body) },
+			wantErr: true,
+		},
+		{
+			name:   "missing timestamp",
+			secret: "signing-secret",
+			request: func() *http.Request {
+				r := slackRequest("signing-secret", time.Now(), body)
+				r.Header.Del("X-Slack-Request-Timestamp")
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no signing secret",
+			request: func() *http.Request { return slackRequest("", time.Now(), body) },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := &SlackTransport{SigningSecret: tt.secret}
+			received := body
+			if tt.body != "" {
+				received = tt.body
+			}
+
+			cmd, err := slack.ParseCommand(tt.request(), []byte(received))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", cmd)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := SlackCommand{UserID: "U024BE7LH", Text: "kitchen off", ResponseURL: "https://hooks.slack.com/commands/1234/5678"}
+			if cmd != want {
+				t.Errorf("got %+v, want %+v", cmd, want)
+			}
+		})
+	}
+}
+
+func TestTelegramParseUpdate(t *testing.T) {
+	private := `{"update_id":1,"message":{"text":"kitchen off","from":{"id":42},"chat":{"id":42,"type":"private"}}}`
+	group := `{"update_id":2,"message":{"text":"kitchen off","from":{"id":42},"chat":{"id":-100,"type":"group"}}}`
+
+	tests := []struct {
+		name     string
+		secret   string // configured
+		header   string // sent
+		body     string
+		wantOK   bool
+		wantFrom string
+		wantErr  bool
+	}{
+		{name: "private message", secret: "s3cret", header: "s3cret", body: private, wantOK: true, wantFrom: "42"},
+		{name: "group message", secret: "s3cret", header: "s3cret", body: group},
+		{name: "no message", secret: "s3cret", header: "s3cret", body: `{"update_id":3}`},
+		{name: "wrong secret", secret: "s3cret", header: "guess", body: private, wantErr: true},
+		{name: "missing secret", secret: "s3cret", body: private, wantErr: true},
+		{name: "no secret configured", body: private, wantErr: true},
+		{name: "invalid JSON", secret: "s3cret", header: "s3cret", body: `{`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			telegram := &TelegramTransport{Token: "bot-token", Secret: tt.secret}
+			r := httptest.NewRequest(http.MethodPost, "/telegram", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Telegram-Bot-Api-Secret-Token", tt.header)
+			}
+
+			from, text, ok, err := telegram.ParseUpdate(r, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if ok != tt.wantOK || from != tt.wantFrom {
+				t.Errorf("got %q, %t; want %q, %t", from, ok, tt.wantFrom, tt.wantOK)
+			}
+			if ok && text != "kitchen off" {
+				t.Errorf("got text %q, want kitchen off", text)
+			}
+		})
+	}
+}
+
+func TestTelegramNotify(t *testing.T) {
+	client := stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/botbot-token/sendMessage" {
+			t.Errorf("request to %s, want sendMessage of the bot", r.URL.Path)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if body["chat_id"] != "42" || body["text"] != "Kitchen: Off" {
+			t.Errorf("got body %v, want the chat and text", body)
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	})
+
+	telegram := &TelegramTransport{Token: "bot-token", Secret: "s3cret", Client: client}
+	if err := telegram.Notify("42", "Kitchen: Off"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebhookValidSecret(t *testing.T) {
+	tests := []struct {
+		name          string
+		secret        string
+		authorization string
+		want          bool
+	}{
+		{"valid", "hook-secret", "Bearer hook-secret", true},
+		{"wrong", "hook-secret", "Bearer guess", false},
+		{"missing", "hook-secret", "", false},
+		{"other scheme", "hook-secret", "Basic hook-secret", false},
+		{"no secret configured", "", "Bearer ", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := &WebhookTransport{URL: "https://example.com/hook", Secret: tt.secret}
+			r := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if got := webhook.ValidSecret(r); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookNotify(t *testing.T) {
+	var got WebhookMessage
+	client := stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer hook-secret" {
+			t.Errorf("got authorization %q, want the secret", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	webhook := &WebhookTransport{URL: "https://example.com/hook", Secret: "hook-secret", Client: client}
+	if err := webhook.Notify("alice", "Kitchen: Off"); err != nil {
+		t.Fatal(err)
+	}
+	if got != (WebhookMessage{To: "alice", Text: "Kitchen: Off"}) {
+		t.Errorf("got %+v, want the message for alice", got)
+	}
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"github.com/twilio/twilio-go"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// TwilioTransport sends text messages through the Twilio REST API.
+type TwilioTransport struct {
+	Client *twilio.RestClient
+	From   string // Twilio phone number the messages are sent from
+}
+
+// Name returns TransportTwilio.
+func (t *TwilioTransport) Name() string {
+	return TransportTwilio
+}
+
+// Notify texts the phone number.
+func (t *TwilioTransport) Notify(phone, text string) error {
+	params := &twilioApi.CreateMessageParams{}
+	params.SetTo(phone)
+	params.SetFrom(t.From)
+	params.SetBody(text)
+	_, err := t.Client.Api.CreateMessage(params)
+	return err
+}
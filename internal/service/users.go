@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUserNotFound is returned when removing a user that does not exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// User is a person allowed to control the lights by text message.
+type User struct {
+	// Phone is the user's phone number, or their address on a chat transport such as
+	// "telegram:123456789".
+	Phone string `json:"phone"`
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+	// Groups limits the groups the user may control. Empty means every group. Each is
+	// named "clientID/Group" after the client that owns it, or by the bare name of the
+	// group on any client.
+	Groups []string `json:"groups,omitempty"`
+	// Actions limits the kinds of action the user may run, such as "status", "update",
+	// "scene", "schedule" and "rules". Empty means every kind.
+	Actions []string `json:"actions,omitempty"`
+}
+
+// CanControl reports whether the user may control the group the client knows by the
+// given name. Names are compared without regard to case.
+func (u User) CanControl(clientID, group string) bool {
+	if len(u.Groups) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(u.Groups, func(g string) bool {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+		client, name, ok := strings.Cut(g, "/")
+		return ok && strings.EqualFold(client, clientID) && strings.EqualFold(name, group)
+	})
+}
+
+// CanControlAll reports whether the user may control every group.
+func (u User) CanControlAll() bool {
+	return len(u.Groups) == 0
+}
+
+// Can reports whether the user may run the kind of action.
+func (u User) Can(action string) bool {
+	return len(u.Actions) == 0 || slices.Contains(u.Actions, action)
+}
+
+// String describes the user in a short line such as "Alice (+14155550123) admin".
+func (u User) String() string {
+	s := fmt.Sprintf("%s (%s)", u.Name, u.Phone)
+	if u.Admin {
+		s += " admin"
+	}
+	if len(u.Groups) > 0 {
+		s += " groups: " + strings.Join(u.Groups, ", ")
+	}
+	if len(u.Actions) > 0 {
+		s += " actions: " + strings.Join(u.Actions, ", ")
+	}
+	return s
+}
+
+// Users is the registry of authorized users, persisted to a JSON file.
+type Users struct {
+	path string
+
+	mu    sync.Mutex
+	users map[string]User
+}
+
+// NewUsers loads the users stored at path. owner is always registered as an admin so
+// the configured number can never lock itself out.
+func NewUsers(path string, owner User) (*Users, error) {
+	us := &Users{path: path, users: make(map[string]User)}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		var stored []User
+		err = json.Unmarshal(data, &stored)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		for _, u := range stored {
+			us.users[u.Phone] = u
+		}
+	}
+
+	if owner.Phone != "" {
+		u, ok := us.users[owner.Phone]
+		if !ok {
+			u = owner
+		}
+		u.Admin = true
+		us.users[owner.Phone] = u
+	}
+	return us, nil
+}
+
+// Lookup returns the user with the given phone number.
+func (us *Users) Lookup(phone string) (User, bool) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	u, ok := us.users[phone]
+	return u, ok
+}
+
+// Add adds or replaces a user.
+func (us *Users) Add(u User) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.users[u.Phone] = u
+	return us.saveLocked()
+}
+
+// Remove removes the user with the given phone number.
+func (us *Users) Remove(phone string) (User, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	u, ok := us.users[phone]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	delete(us.users, phone)
+	return u, us.saveLocked()
+}
+
+// List returns every user ordered by name.
+func (us *Users) List() []User {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	list := make([]User, 0, len(us.users))
+	for _, u := range us.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// saveLocked writes the users to disk. The caller must hold us.mu.
+func (us *Users) saveLocked() error {
+	list := make([]User, 0, len(us.users))
+	for _, u := range us.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Phone < list[j].Phone })
+
+	return writeJSONFile(us.path, list)
+}
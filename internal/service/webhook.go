@@ -0,0 +1,52 @@
+package service
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// WebhookMessage is the JSON body of messages exchanged with the generic webhook
+// transport, both inbound and outbound.
+type WebhookMessage struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	Text string `json:"text"`
+}
+
+// WebhookTransport posts outbound messages as JSON to a configured URL, so any
+// system that can receive a webhook can be notified. The same system may post
+// inbound messages back with the secret as its bearer token.
+type WebhookTransport struct {
+	URL    string
+	Secret string // sent as a bearer token when set, and required on inbound messages
+	Client *http.Client
+}
+
+// InboundEnabled reports whether inbound messages are accepted, which needs a secret.
+func (t *WebhookTransport) InboundEnabled() bool {
+	return t.Secret != ""
+}
+
+// ValidSecret reports whether r carries the secret as its bearer token.
+func (t *WebhookTransport) ValidSecret(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !t.InboundEnabled() {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(t.Secret)) == 1
+}
+
+// Name returns TransportWebhook.
+func (t *WebhookTransport) Name() string {
+	return TransportWebhook
+}
+
+// Notify posts the message for the recipient to the webhook URL.
+func (t *WebhookTransport) Notify(id, text string) error {
+	header := http.Header{}
+	if t.Secret != "" {
+		header.Set("Authorization", "Bearer "+t.Secret)
+	}
+	return postJSON(t.Client, t.URL, header, WebhookMessage{To: id, Text: text}, nil)
+}